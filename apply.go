@@ -0,0 +1,197 @@
+package sdiffer
+
+import (
+	"reflect"
+)
+
+// Apply applies diffs found by Differ onto target, which must be a non-nil pointer to a value
+// of the type compared as A, so that the value becomes B.
+//
+// Struct fields, slice elements, map entries, pointers and lengths of slices are all patched
+// in place, while slices compared by a Sorter or WithSliceKey and values compared by a customized
+// Comparator are replaced as a whole. Diffs of pointer cycles are skipped.
+func Apply(target interface{}, diffs []*Diff) error {
+	return applyOps(target, buildPatchOps(diffs))
+}
+
+func applyOps(target interface{}, ops []patchOp) (err error) {
+	defer catch(&err)
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &ApplyError{Path: initTypeName, Reason: "target must be a non-nil pointer"}
+	}
+	for _, op := range ops {
+		applyRoot(rv, op)
+	}
+	return nil
+}
+
+// applyRoot applies op onto the pointer rv, which points to either the root value of op,
+// or an interface holding the root value.
+func applyRoot(rv reflect.Value, op patchOp) {
+	fail := func(reason string) {
+		throw(&ApplyError{Path: op.path.String(), Reason: reason})
+	}
+	root, t := rv, op.path[0].typ
+	if t != nil && t != root.Type() {
+		root = rv.Elem()
+	}
+	if t != nil && t != root.Type() && root.Kind() == reflect.Interface && !root.IsNil() {
+		elem := addressableValue(root.Elem())
+		defer root.Set(elem)
+		root = elem
+	}
+	if t != nil && t != root.Type() {
+		fail("target type mismatch: " + rv.Type().String())
+	}
+	if len(op.path) > 1 {
+		applyStep(root, op.path[1:], op)
+		return
+	}
+	setValue(root, op.value, fail)
+}
+
+// Apply applies the diffs of d onto target, see Apply.
+func (d *Differ) Apply(target interface{}) error {
+	return d.result().Apply(target)
+}
+
+// Apply applies the diffs of r onto target, see Apply.
+func (r *Result) Apply(target interface{}) error {
+	return Apply(target, r.diffList)
+}
+
+// applyStep walks from v along steps and applies op at the last step,
+// v must be settable unless it's a pointer, a map or an interface.
+func applyStep(v reflect.Value, steps Path, op patchOp) {
+	s, last := steps[0], len(steps) == 1
+	fail := func(reason string) {
+		throw(&ApplyError{Path: op.path[:len(op.path)-len(steps)+1].String(), Reason: reason})
+	}
+
+	switch s.kind {
+	case FieldStep:
+		if v.Kind() != reflect.Struct {
+			fail("not a struct: " + v.Type().String())
+		}
+		f := v.FieldByName(s.name)
+		if s.owner == v.Type() {
+			f = v.Field(s.index)
+		}
+		if !f.IsValid() {
+			fail("no such field")
+		}
+		if !f.CanSet() && f.CanAddr() {
+			f = exportValue(f)
+		}
+		if last {
+			setValue(f, op.value, fail)
+			return
+		}
+		applyStep(f, steps[1:], op)
+
+	case IndexStep:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			fail("not a slice: " + v.Type().String())
+		}
+		if last && op.op == opAdd {
+			if v.Kind() != reflect.Slice || s.index > v.Len() {
+				fail("index out of range")
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			setValue(elem, op.value, fail)
+			grown := reflect.Append(v, elem)
+			reflect.Copy(grown.Slice(s.index+1, grown.Len()), grown.Slice(s.index, grown.Len()-1))
+			grown.Index(s.index).Set(elem)
+			v.Set(grown)
+			return
+		}
+		if s.index >= v.Len() {
+			fail("index out of range")
+		}
+		if last && op.op == opRemove {
+			if v.Kind() != reflect.Slice {
+				fail("can not remove an element from an array")
+			}
+			reflect.Copy(v.Slice(s.index, v.Len()), v.Slice(s.index+1, v.Len()))
+			v.Set(v.Slice(0, v.Len()-1))
+			return
+		}
+		if last {
+			setValue(v.Index(s.index), op.value, fail)
+			return
+		}
+		applyStep(v.Index(s.index), steps[1:], op)
+
+	case MapKeyStep:
+		if v.Kind() != reflect.Map {
+			fail("not a map: " + v.Type().String())
+		}
+		key := reflect.New(v.Type().Key()).Elem()
+		setValue(key, s.key, fail)
+		if v.IsNil() {
+			if !last || op.op == opRemove {
+				fail("map is nil")
+			}
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		if last && op.op == opRemove {
+			v.SetMapIndex(key, reflect.Value{})
+			return
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if last {
+			setValue(elem, op.value, fail)
+		} else {
+			if old := v.MapIndex(key); old.IsValid() {
+				elem.Set(old)
+			} else {
+				fail("no such key")
+			}
+			applyStep(elem, steps[1:], op)
+		}
+		v.SetMapIndex(key, elem)
+
+	case PtrStep:
+		if v.Kind() != reflect.Ptr || v.IsNil() {
+			fail("not a non-nil pointer")
+		}
+		if last {
+			setValue(v.Elem(), op.value, fail)
+			return
+		}
+		applyStep(v.Elem(), steps[1:], op)
+
+	case TypeAssertStep:
+		if v.Kind() != reflect.Interface || v.IsNil() {
+			fail("not a non-nil interface")
+		}
+		if last {
+			setValue(v, op.value, fail)
+			return
+		}
+		elem := addressableValue(v.Elem())
+		applyStep(elem, steps[1:], op)
+		v.Set(elem)
+
+	default:
+		fail("unexpected step " + s.kind.String())
+	}
+}
+
+// setValue sets dst to a deep copy of value, so that dst does not share pointers, slices or maps
+// with value. dst is set to its zero value if value is nil.
+func setValue(dst reflect.Value, value interface{}, fail func(reason string)) {
+	if !dst.CanSet() {
+		fail("value can not be set")
+	}
+	if value == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return
+	}
+	rv := reflect.ValueOf(value)
+	if !rv.Type().AssignableTo(dst.Type()) {
+		fail("can not assign " + rv.Type().String() + " to " + dst.Type().String())
+	}
+	dst.Set(deepCopy(rv, make(map[visit]reflect.Value)))
+}
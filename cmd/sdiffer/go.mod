@@ -0,0 +1,11 @@
+module github.com/sshelll/sdiffer/cmd/sdiffer
+
+go 1.16
+
+require (
+	github.com/sshelll/sdiffer v0.0.0-00010101000000-000000000000
+	github.com/stretchr/testify v1.8.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+replace github.com/sshelll/sdiffer => ../..
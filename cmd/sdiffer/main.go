@@ -0,0 +1,267 @@
+// Command sdiffer compares two JSON or YAML files and prints their diffs.
+//
+// Usage:
+//
+//	sdiffer [flags] <file-a> <file-b>
+//
+// A file named "-" is read from stdin, at most one of the files can be "-". The -ignore and
+// -include flags can not be used together. The exit code is 0 if the files are equal,
+// 1 if they are different, and 2 if an error occurs.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sshelll/sdiffer"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	exitEqual     = 0
+	exitDifferent = 1
+	exitError     = 2
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// stringsFlag is a flag which can be set multiple times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+type options struct {
+	ignores   stringsFlag
+	includes  stringsFlag
+	trims     stringsFlag
+	unordered stringsFlag
+	maxDepth  int
+	input     string
+	output    string
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	opts := &options{}
+	fs := flag.NewFlagSet("sdiffer", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: sdiffer [flags] <file-a> <file-b>")
+		fs.PrintDefaults()
+	}
+	fs.Var(&opts.ignores, "ignore", "ignore the diffs whose JSON Pointer matches the regexp, can be repeated")
+	fs.Var(&opts.includes, "include", "only report the diffs whose JSON Pointer matches the regexp, can be repeated, conflicts with -ignore")
+	fs.Var(&opts.trims, "trim-space", "trim the spaces of the strings whose JSON Pointer matches the regexp, can be repeated")
+	fs.Var(&opts.unordered, "unordered", "compare the arrays whose JSON Pointer matches the regexp regardless of order, can be repeated")
+	fs.IntVar(&opts.maxDepth, "max-depth", 0, "max depth of the comparison, 0 means the default depth")
+	fs.StringVar(&opts.input, "input", "auto", "input format: auto, json or yaml, auto detects the format by file extension")
+	fs.StringVar(&opts.output, "output", "text", "output format: text, json or patch")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitEqual
+		}
+		return exitError
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return exitError
+	}
+	if err := opts.validate(fs.Arg(0), fs.Arg(1)); err != nil {
+		fmt.Fprintln(stderr, "sdiffer:", err)
+		return exitError
+	}
+
+	differ, err := opts.newDiffer()
+	if err != nil {
+		fmt.Fprintln(stderr, "sdiffer:", err)
+		return exitError
+	}
+	docA, err := readDocument(fs.Arg(0), opts.input, stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, "sdiffer:", err)
+		return exitError
+	}
+	docB, err := readDocument(fs.Arg(1), opts.input, stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, "sdiffer:", err)
+		return exitError
+	}
+	if err = differ.CompareJSON(docA, docB); err != nil {
+		fmt.Fprintln(stderr, "sdiffer:", err)
+		return exitError
+	}
+	if err = writeDiffs(stdout, differ, opts.output); err != nil {
+		fmt.Fprintln(stderr, "sdiffer:", err)
+		return exitError
+	}
+	if len(differ.Diffs()) > 0 {
+		return exitDifferent
+	}
+	return exitEqual
+}
+
+// validate rejects the flags and files which can not be used together.
+func (opts *options) validate(fileA, fileB string) error {
+	if len(opts.ignores) > 0 && len(opts.includes) > 0 {
+		return errors.New("-ignore and -include can not be used together")
+	}
+	if fileA == "-" && fileB == "-" {
+		return errors.New("stdin can not be read as both files")
+	}
+	return nil
+}
+
+func (opts *options) newDiffer() (*sdiffer.Differ, error) {
+	differ := sdiffer.NewDiffer()
+	if err := differ.IgnoreE(opts.ignores...); err != nil {
+		return nil, err
+	}
+	if err := differ.IncludesE(opts.includes...); err != nil {
+		return nil, err
+	}
+	if err := differ.WithTrimSpaceE(opts.trims...); err != nil {
+		return nil, err
+	}
+	for _, expr := range opts.unordered {
+		s, err := newUnorderedSorter(expr)
+		if err != nil {
+			return nil, err
+		}
+		differ.WithSorter(s)
+	}
+	if opts.maxDepth > 0 {
+		differ.WithMaxDepth(opts.maxDepth)
+	}
+	return differ, nil
+}
+
+// unorderedSorter sorts arrays by the JSON encodings of their elements.
+type unorderedSorter struct {
+	reg *regexp.Regexp
+}
+
+func newUnorderedSorter(expr string) (*unorderedSorter, error) {
+	reg, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unordered rule %q: %v", expr, err)
+	}
+	return &unorderedSorter{reg: reg}, nil
+}
+
+func (s *unorderedSorter) Match(fieldPath string) bool {
+	return s.reg.MatchString(fieldPath)
+}
+
+func (s *unorderedSorter) Less(a, b interface{}) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Compare(ja, jb) < 0
+}
+
+// readDocument reads a JSON or YAML file, YAML documents are converted to JSON.
+func readDocument(name, format string, stdin io.Reader) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if name == "-" {
+		data, err = ioutil.ReadAll(stdin)
+	} else {
+		data, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "auto" {
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".yaml", ".yml":
+			format = "yaml"
+		default:
+			format = "json"
+		}
+	}
+	switch format {
+	case "json":
+		return data, nil
+	case "yaml":
+		var doc interface{}
+		if err = yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return json.Marshal(normalizeYAML(doc))
+	}
+	return nil, fmt.Errorf("unknown input format %q", format)
+}
+
+// normalizeYAML converts the maps with non-string keys decoded from YAML to map[string]interface{},
+// so that the document can be encoded as JSON.
+func normalizeYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, elem := range v {
+			v[k] = normalizeYAML(elem)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			m[fmt.Sprint(k)] = normalizeYAML(elem)
+		}
+		return m
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = normalizeYAML(elem)
+		}
+		return v
+	}
+	return v
+}
+
+type jsonDiff struct {
+	Path string      `json:"path"`
+	Kind string      `json:"kind"`
+	A    interface{} `json:"a,omitempty"`
+	B    interface{} `json:"b,omitempty"`
+}
+
+func writeDiffs(w io.Writer, differ *sdiffer.Differ, format string) error {
+	switch format {
+	case "text":
+		_, err := io.WriteString(w, differ.String())
+		return err
+	case "json":
+		diffs := make([]jsonDiff, 0)
+		for _, df := range differ.Diffs() {
+			diffs = append(diffs, jsonDiff{Path: df.Name(), Kind: df.Kind().String(), A: df.A(), B: df.B()})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diffs)
+	case "patch":
+		patch, err := differ.JSONPatch()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", patch)
+		return err
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
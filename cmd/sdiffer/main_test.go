@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MainTestSuite struct {
+	suite.Suite
+	dir string
+}
+
+func TestSdiffer(t *testing.T) {
+	suite.Run(t, new(MainTestSuite))
+}
+
+func (suite *MainTestSuite) SetupTest() {
+	suite.dir = suite.T().TempDir()
+}
+
+func (suite *MainTestSuite) writeFile(name, content string) string {
+	path := filepath.Join(suite.dir, name)
+	suite.Require().NoError(ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func (suite *MainTestSuite) run(stdin string, args ...string) (code int, stdout, stderr string) {
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	code = run(args, strings.NewReader(stdin), out, errOut)
+	return code, out.String(), errOut.String()
+}
+
+func (suite *MainTestSuite) TestEqual() {
+	a := suite.writeFile("a.json", `{"name":"a","tags":["x","y"],"n":1.0}`)
+	b := suite.writeFile("b.yaml", "name: ' a '\ntags: [y, x]\nn: 1\n")
+	code, stdout, _ := suite.run("", "-trim-space", "^/name$", "-unordered", "^/tags$", a, b)
+	suite.Equal(exitEqual, code)
+	suite.Empty(stdout)
+
+	code, _, _ = suite.run(`{"n":1}`, "-", a, "-ignore", ".")
+	suite.Equal(exitError, code, "flags after the files are not parsed")
+	code, _, _ = suite.run(`{"name":"b","n":1}`, "-include", "^/n$", "-", a)
+	suite.Equal(exitEqual, code)
+}
+
+func (suite *MainTestSuite) TestDifferent() {
+	a := suite.writeFile("a.yml", "name: a\nitems:\n  - id: 1\n  - id: 2\n")
+	b := suite.writeFile("b.json", `{"name":"b","items":[{"id":1},{"id":3}]}`)
+	code, stdout, _ := suite.run("", a, b)
+	suite.Equal(exitDifferent, code)
+	suite.Contains(stdout, `Field: "/name", A: a, B: b`)
+	suite.Contains(stdout, `Field: "/items/1/id", A: 2, B: 3`)
+
+	code, stdout, _ = suite.run("", "-output", "json", "-ignore", "^/items", a, b)
+	suite.Equal(exitDifferent, code)
+	var diffs []jsonDiff
+	suite.NoError(json.Unmarshal([]byte(stdout), &diffs))
+	suite.Equal([]jsonDiff{{Path: "/name", Kind: "Changed", A: "a", B: "b"}}, diffs)
+
+	code, stdout, _ = suite.run("", "-output", "patch", a, b)
+	suite.Equal(exitDifferent, code)
+	suite.JSONEq(`[{"op":"replace","path":"/name","value":"b"},{"op":"replace","path":"/items/1/id","value":3}]`, stdout)
+}
+
+func (suite *MainTestSuite) TestError() {
+	a := suite.writeFile("a.json", `{"a":{"b":{"c":1}}}`)
+	b := suite.writeFile("b.json", `{"a":{"b":{"c":2}}}`)
+	bad := suite.writeFile("bad.json", `{`)
+	cases := [][]string{
+		{a},
+		{a, filepath.Join(suite.dir, "missing.json")},
+		{a, bad},
+		{"-max-depth", "1", a, b},
+		{"-ignore", "(", a, b},
+		{"-unordered", "(", a, b},
+		{"-input", "xml", a, b},
+		{"-output", "xml", a, b},
+		{"-unknown", a, b},
+		{"-ignore", "^/a$", "-include", "^/a", a, b},
+		{"-", "-"},
+	}
+	for _, args := range cases {
+		code, _, stderr := suite.run("", args...)
+		suite.Equal(exitError, code, args)
+		suite.NotEmpty(stderr, args)
+	}
+}
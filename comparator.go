@@ -1,5 +1,7 @@
 package sdiffer
 
+import "reflect"
+
 type DiffType int
 
 const (
@@ -20,6 +22,7 @@ const (
 type Comparator interface {
 
 	// Match checks if a field should use this comparator.
+	// If the comparator also implements PathMatcher or TypeMatcher, MatchPath or MatchType will be used instead.
 	Match(fieldPath string) bool
 
 	// Equals compares two interfaces and return a DiffType among LengthDiff, NilDiff, ElemDiff
@@ -32,3 +35,9 @@ type Comparator interface {
 	// See Differ.Compare for more details.
 	Equals(a, b interface{}) (dt DiffType, msgA, msgB interface{})
 }
+
+// TypeMatcher can be implemented by a Comparator or a Sorter to match against the type of
+// the compared values as well as the field path, so that it can handle a type anywhere.
+type TypeMatcher interface {
+	MatchType(fieldPath string, t reflect.Type) bool
+}
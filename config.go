@@ -0,0 +1,92 @@
+package sdiffer
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Config is an immutable snapshot of the rules of a Differ, see Differ.Config.
+//
+// Unlike Differ, a Config can be shared by goroutines, each comparison returns a new Result.
+// The Comparators, Sorters and functions used by the rules must be safe for concurrent use.
+//
+// For example:
+// config := NewDiffer().Ignore(`\.UpdatedAt$`).Config()
+// result, err := config.Compare(a, b)
+type Config struct {
+	rules rules
+}
+
+// Compare compares a and b with the rules of c, see Differ.CompareE.
+func (c *Config) Compare(a, b interface{}) (*Result, error) {
+	d := newDifferWithRules(c.rules)
+	if err := d.CompareE(a, b); err != nil {
+		return nil, err
+	}
+	return d.result(), nil
+}
+
+// CompareJSON compares two JSON documents with the rules of c, see Differ.CompareJSON.
+func (c *Config) CompareJSON(a, b []byte) (*Result, error) {
+	d := newDifferWithRules(c.rules)
+	if err := d.CompareJSON(a, b); err != nil {
+		return nil, err
+	}
+	return d.result(), nil
+}
+
+// Result holds the diffs found by a comparison.
+type Result struct {
+	diffs    map[string]*Diff
+	diffList []*Diff
+	tmpl     string
+}
+
+// Equal checks if no diff is found.
+func (r *Result) Equal() bool {
+	return len(r.diffList) == 0
+}
+
+// Diffs returns the diffs in the order they were found.
+func (r *Result) Diffs() []*Diff {
+	dfs := make([]*Diff, len(r.diffList))
+	copy(dfs, r.diffList)
+	return dfs
+}
+
+// FindDiff find diff with name.
+func (r *Result) FindDiff(fieldName string) (df *Diff, ok bool) {
+	df, ok = r.diffs[fieldName]
+	return
+}
+
+// FindDiffByPath find diff with a structured Path.
+func (r *Result) FindDiffByPath(p Path) (df *Diff, ok bool) {
+	for _, df = range r.diffList {
+		if df.path.Equal(p) {
+			return df, true
+		}
+	}
+	return nil, false
+}
+
+// FindDiffFuzzily find diff with regexp.
+func (r *Result) FindDiffFuzzily(expr string) (dfs []*Diff) {
+	if reg, err := regexp.Compile(expr); err == nil {
+		for _, df := range r.diffList {
+			if reg.MatchString(df.name) {
+				dfs = append(dfs, df)
+			}
+		}
+	}
+	return
+}
+
+func (r *Result) String() string {
+	builder := &strings.Builder{}
+	for _, df := range r.diffList {
+		builder.WriteString(df.String(r.tmpl))
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
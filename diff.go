@@ -2,59 +2,136 @@ package sdiffer
 
 import (
 	"fmt"
-	"strings"
+	"reflect"
 )
 
 const defaultDiffTmpl = `Field: "%s", A: %v, B: %v`
 
-type diff struct {
+// DiffKind describes what kind of change a Diff represents.
+type DiffKind int
+
+const (
+	// Changed means the values of A and B are different.
+	Changed DiffKind = iota
+
+	// Added means the element only exists in B, such as a map key.
+	Added
+
+	// Removed means the element only exists in A, such as a map key.
+	Removed
+
+	// NilMismatch means only one of A and B is nil.
+	NilMismatch
+
+	// LengthMismatch means A and B have different lengths.
+	LengthMismatch
+
+	// CustomComparator means the diff is reported by a customized Comparator.
+	CustomComparator
+
+	// CycleMismatch means only one of A and B points back to its ancestor,
+	// or they point back to different ancestors.
+	CycleMismatch
+)
+
+var diffKindNames = [...]string{
+	Changed:          "Changed",
+	Added:            "Added",
+	Removed:          "Removed",
+	NilMismatch:      "NilMismatch",
+	LengthMismatch:   "LengthMismatch",
+	CustomComparator: "CustomComparator",
+	CycleMismatch:    "CycleMismatch",
+}
+
+func (k DiffKind) String() string {
+	if k >= 0 && int(k) < len(diffKindNames) {
+		return diffKindNames[k]
+	}
+	return fmt.Sprintf("DiffKind(%d)", int(k))
+}
+
+// Diff is a difference found by Differ.
+type Diff struct {
 	name string
+	path Path
+	kind DiffKind
+	typ  reflect.Type
+	a    interface{}
+	b    interface{}
 	va   interface{}
 	vb   interface{}
 }
 
-func newDiff(name string, a, b interface{}) *diff {
-	return &diff{
+func newDiff(kind DiffKind, path Path, name string, typ reflect.Type, a, b, va, vb interface{}) *Diff {
+	return &Diff{
 		name: name,
-		va:   a,
-		vb:   b,
+		path: path.clone(),
+		kind: kind,
+		typ:  typ,
+		a:    a,
+		b:    b,
+		va:   va,
+		vb:   vb,
 	}
 }
 
-func (d *diff) Name() string {
+// Name returns the full name of the diff, such as Person.Parents[0].Name.
+func (d *Diff) Name() string {
 	return d.name
 }
 
-func (d *diff) Va() interface{} {
+// Kind returns the kind of the diff.
+func (d *Diff) Kind() DiffKind {
+	return d.kind
+}
+
+// Path returns the structured path where the diff was found.
+func (d *Diff) Path() Path {
+	return d.path
+}
+
+// Type returns the type of the compared values, it's nil if the type is unknown.
+func (d *Diff) Type() reflect.Type {
+	return d.typ
+}
+
+// A returns the raw value of A, it's nil if the element does not exist in A.
+func (d *Diff) A() interface{} {
+	return d.a
+}
+
+// B returns the raw value of B, it's nil if the element does not exist in B.
+func (d *Diff) B() interface{} {
+	return d.b
+}
+
+// Va returns the value of A which is used to display the diff.
+func (d *Diff) Va() interface{} {
 	return d.va
 }
 
-func (d *diff) Vb() interface{} {
+// Vb returns the value of B which is used to display the diff.
+func (d *Diff) Vb() interface{} {
 	return d.vb
 }
 
 // Tag generate a short tag of the diff name.
 // For example:
 // Person.Schools[0].Buildings[2].Name => Person.Schools.Buildings.Name
-func (d *diff) Tag() (tag string) {
-	cut := func(str string) string {
-		idx := strings.Index(str, "[")
-		if idx > 0 {
-			return str[:idx]
-		}
-		return str
-	}
-	words := strings.Split(d.name, ".")
-	for _, word := range words {
-		if strings.HasSuffix(word, "]") {
-			word = cut(word)
+func (d *Diff) Tag() (tag string) {
+	for _, s := range d.path {
+		switch s.kind {
+		case RootStep:
+			tag = s.name
+		case FieldStep:
+			tag = concat(tag, ".", s.displayName())
 		}
-		tag = iF(isStringBlank(tag), word, concat(tag, ".", word)).(string)
 	}
 	return
 }
 
-func (d *diff) String(tmpl ...string) string {
+func (d *Diff) String(tmpl ...string) string {
 	for _, t := range tmpl {
 		if !isStringBlank(t) {
 			return fmt.Sprintf(t, d.name, d.va, d.vb)
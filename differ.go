@@ -1,11 +1,10 @@
 package sdiffer
 
 import (
-	"fmt"
+	"encoding/json"
+	"math"
 	. "reflect"
 	"regexp"
-	"strconv"
-	"strings"
 )
 
 type diffMode int
@@ -16,10 +15,24 @@ const (
 	allDiffMode
 )
 
+type unexportedMode int
+
+const (
+	// reportUnexported returns an *UnexportedFieldError when an unexported field has to be read.
+	reportUnexported unexportedMode = iota
+	// allowUnexported reads unexported fields via reflection.
+	allowUnexported
+	// ignoreUnexported skips unexported fields silently.
+	ignoreUnexported
+)
+
 const (
 	initTypeName        = "$"
 	null                = "<nil>"
 	notNull             = "<not nil>"
+	missing             = "<missing>"
+	cyclePrefix         = "<cycle to "
+	noCycle             = "<no cycle>"
 	useComparatorSuffix = ".$[customized]"
 	defaultDepthLimit   = 30
 )
@@ -30,45 +43,102 @@ const (
 // differ := NewDiffer().Ignore(`xxx`, `xxx`).Compare(a, b)
 //
 // Attention:
-// Differ may cause panic when you call Compare.
+// Differ may cause panic when you call Compare, use CompareE if you want an error instead.
 type Differ struct {
-	diffs       map[string]*diff
-	ignores     []*regexp.Regexp
-	includes    []*regexp.Regexp
-	trimSpaces  []*regexp.Regexp
-	trimTags    []*trimTag
-	comparators []Comparator
-	sorters     []Sorter
-	maxDepth    int
-	diffTmpl    string
-	bff         *bufferF
+	rules
+	diffs     map[string]*Diff
+	diffList  []*Diff
+	jsonPaths bool
+	sink      func(df *Diff)
+	visitedA  map[visit]visitRecord
+	visitedB  map[visit]visitRecord
+	bff       *bufferF
+}
+
+// rules are the comparison rules of Differ, which are read only during the comparison.
+type rules struct {
+	ignores      []*regexp.Regexp
+	includes     []*regexp.Regexp
+	ignoreFuncs  []func(Path) bool
+	includeFuncs []func(Path) bool
+	trimSpaces   []*regexp.Regexp
+	editScripts  []*regexp.Regexp
+	sliceKeys    []*sliceKey
+	trimTags     []*trimTag
+	transforms   []*transform
+	comparators  []Comparator
+	sorters      []Sorter
+	maxDepth     int
+	diffTmpl     string
+	unexported   unexportedMode
+	postFilter   bool
+	equalMethods bool
+	ignoreTypes  map[Type]bool
+	typeCmps     map[Type]func(a, b interface{}) bool
+	plans        *planCache
+}
+
+// clone returns a copy of r which shares no slices with r.
+func (r rules) clone() rules {
+	r.ignores = append([]*regexp.Regexp(nil), r.ignores...)
+	r.includes = append([]*regexp.Regexp(nil), r.includes...)
+	r.ignoreFuncs = append([]func(Path) bool(nil), r.ignoreFuncs...)
+	r.includeFuncs = append([]func(Path) bool(nil), r.includeFuncs...)
+	r.trimSpaces = append([]*regexp.Regexp(nil), r.trimSpaces...)
+	r.editScripts = append([]*regexp.Regexp(nil), r.editScripts...)
+	r.sliceKeys = append([]*sliceKey(nil), r.sliceKeys...)
+	r.trimTags = append([]*trimTag(nil), r.trimTags...)
+	r.transforms = append([]*transform(nil), r.transforms...)
+	r.comparators = append([]Comparator(nil), r.comparators...)
+	r.sorters = append([]Sorter(nil), r.sorters...)
+	r.ignoreTypes = copyTypeSet(r.ignoreTypes)
+	r.typeCmps = copyTypeComparators(r.typeCmps)
+	r.plans = newPlanCache(&r)
+	return r
+}
+
+// visit identifies a pointer which has been visited during the comparison.
+type visit struct {
+	typ Type
+	ptr uintptr
+}
+
+// visitRecord records which pointer a pointer was compared with, and the length of the path
+// where it was visited, the path is always a prefix of the current one.
+type visitRecord struct {
+	peer    uintptr
+	pathLen int
 }
 
 func NewDiffer() *Differ {
+	return newDifferWithRules(rules{maxDepth: defaultDepthLimit})
+}
+
+func newDifferWithRules(r rules) *Differ {
 	return &Differ{
-		diffs:    make(map[string]*diff, 16),
-		bff:      newBufferF(),
-		maxDepth: defaultDepthLimit,
+		rules: r,
+		diffs: make(map[string]*Diff, 16),
+		bff:   newBufferF(),
 	}
 }
 
 func (d *Differ) String() string {
-	for _, df := range d.diffs {
+	for _, df := range d.diffList {
 		d.bff.sprintf("%s\n", df.String(d.diffTmpl))
 	}
 	return d.bff.String()
 }
 
-func (d *Differ) Diffs() []*diff {
-	dfs := make([]*diff, 0, len(d.diffs))
-	for _, df := range d.diffs {
-		dfs = append(dfs, df)
-	}
+// Diffs returns the diffs in the order they were found.
+func (d *Differ) Diffs() []*Diff {
+	dfs := make([]*Diff, len(d.diffList))
+	copy(dfs, d.diffList)
 	return dfs
 }
 
 // WithMaxDepth set the max depth of Differ.
-// Differ will panic if depth is over max depth when comparing.
+// CompareE returns a *DepthExceededError if depth is over max depth when comparing,
+// while Compare panics with it.
 func (d *Differ) WithMaxDepth(depth int) *Differ {
 	d.maxDepth = depth
 	return d
@@ -82,238 +152,585 @@ func (d *Differ) WithTmpl(tmpl string) *Differ {
 	return d
 }
 
-// Ignore set fields that do not need to be compared.
+// AllowUnexported makes Differ compare unexported struct fields by reading them via reflection.
+func (d *Differ) AllowUnexported() *Differ {
+	d.unexported = allowUnexported
+	return d
+}
+
+// IgnoreUnexported makes Differ skip unexported struct fields silently.
+//
+// Without AllowUnexported or IgnoreUnexported, Differ fails with an *UnexportedFieldError
+// once it has to read the value of an unexported field.
+func (d *Differ) IgnoreUnexported() *Differ {
+	d.unexported = ignoreUnexported
+	return d
+}
+
+// Ignore set fields that do not need to be compared, the fields matching the regexps and
+// their descendants are not traversed at all, unless WithPostFilter is called.
 // Ignore will not work after Includes is called.
+// It panics with an *InvalidRuleError if any of the regexps is invalid, see IgnoreE.
 func (d *Differ) Ignore(regexps ...string) *Differ {
-	if len(d.includes) > 0 {
-		return d
+	mustSuccess(func() error {
+		return d.IgnoreE(regexps...)
+	})
+	return d
+}
+
+// IgnoreE is like Ignore but returns an *InvalidRuleError instead of panicking.
+func (d *Differ) IgnoreE(regexps ...string) error {
+	if len(d.includes) > 0 || len(d.includeFuncs) > 0 {
+		return nil
 	}
-	d.ignores = make([]*regexp.Regexp, 0, len(regexps))
-	for _, expr := range regexps {
-		d.ignores = append(d.ignores, regexp.MustCompile(expr))
+	ignores, err := compileRules("ignore", regexps)
+	if err != nil {
+		return err
+	}
+	d.ignores = ignores
+	d.plans = nil
+	return nil
+}
+
+// IgnorePath is like Ignore but checks the structured Path of the fields with fns.
+// IgnorePath will not work after Includes or IncludesPath is called.
+// The Path passed to fns is only valid during the call, like the one of PathMatcher.
+func (d *Differ) IgnorePath(fns ...func(p Path) bool) *Differ {
+	if len(d.includes) > 0 || len(d.includeFuncs) > 0 {
+		return d
 	}
+	d.ignoreFuncs = append(d.ignoreFuncs, fns...)
 	return d
 }
 
-// Includes set fields that need to be compared.
+// Includes set fields that need to be compared, the fields matching the regexps are compared
+// with their descendants, and the fields which can not lead to them are not traversed at all.
+// A regexp anchored with '^' is needed to tell whether a field can lead to the matching fields
+// by its literal prefix, such as `^Person\.Parents`.
 // Ignore will not work after Includes is called.
+// It panics with an *InvalidRuleError if any of the regexps is invalid, see IncludesE.
 func (d *Differ) Includes(regexps ...string) *Differ {
-	d.includes = make([]*regexp.Regexp, 0, len(regexps))
-	for _, expr := range regexps {
-		d.includes = append(d.includes, regexp.MustCompile(expr))
+	mustSuccess(func() error {
+		return d.IncludesE(regexps...)
+	})
+	return d
+}
+
+// IncludesE is like Includes but returns an *InvalidRuleError instead of panicking.
+func (d *Differ) IncludesE(regexps ...string) error {
+	includes, err := compileRules("include", regexps)
+	if err != nil {
+		return err
 	}
+	d.includes = includes
+	d.plans = nil
+	return nil
+}
+
+// IncludesPath is like Includes but checks the structured Path of the fields with fns.
+// The Path passed to fns is only valid during the call, like the one of PathMatcher.
+func (d *Differ) IncludesPath(fns ...func(p Path) bool) *Differ {
+	d.includeFuncs = append(d.includeFuncs, fns...)
+	return d
+}
+
+// WithPostFilter makes Differ traverse all the fields, and filter the diffs by their names with
+// the rules of Ignore and Includes after they are found, instead of pruning the traversal.
+// Then a diff under an ignored field is still reported unless its own name is ignored,
+// and a diff under an included field is dropped unless its own name is included.
+func (d *Differ) WithPostFilter() *Differ {
+	d.postFilter = true
+	return d
+}
+
+// WithEqualMethods makes Differ compare the values having an Equal method with the method
+// instead of comparing their fields or elements, a single diff is reported if they are not equal.
+// The method must be defined as (T) Equal(U) bool, where T is assignable to U, such as
+// time.Time.Equal or Equaler.Equal. Comparators take precedence over the Equal methods.
+func (d *Differ) WithEqualMethods() *Differ {
+	d.equalMethods = true
+	return d
+}
+
+// IgnoreTypes set types that do not need to be compared, the values of the types are not
+// traversed wherever they are, including the ones held by interfaces. Added or removed values
+// of the types are not reported either, nor are the length diffs of slices and maps whose
+// elements are of the types.
+//
+// For example:
+// differ := NewDiffer().IgnoreTypes(reflect.TypeOf((*sync.Mutex)(nil)).Elem())
+func (d *Differ) IgnoreTypes(types ...Type) *Differ {
+	if d.ignoreTypes == nil {
+		d.ignoreTypes = make(map[Type]bool, len(types))
+	}
+	for _, t := range types {
+		d.ignoreTypes[t] = true
+	}
+	return d
+}
+
+// WithTypeComparator compares the values of type t with equal wherever they are, a single diff is
+// reported if equal returns false. Comparators set by WithComparator take precedence over it.
+//
+// For example:
+//
+//	differ := NewDiffer().WithTypeComparator(reflect.TypeOf(time.Time{}), func(a, b interface{}) bool {
+//		return a.(time.Time).Unix() == b.(time.Time).Unix()
+//	})
+func (d *Differ) WithTypeComparator(t Type, equal func(a, b interface{}) bool) *Differ {
+	if d.typeCmps == nil {
+		d.typeCmps = make(map[Type]func(a, b interface{}) bool)
+	}
+	d.typeCmps[t] = equal
 	return d
 }
 
 // WithComparator specify some fields to compare with a customized Comparator.
 func (d *Differ) WithComparator(c Comparator) *Differ {
 	d.comparators = append(d.comparators, c)
+	d.plans = nil
 	return d
 }
 
 // WithSorter sort some fields to do disordered comparison.
 func (d *Differ) WithSorter(s Sorter) *Differ {
 	d.sorters = append(d.sorters, s)
+	d.plans = nil
 	return d
 }
 
 // WithTrim trim string before comparison.
+// It panics with an *InvalidRuleError if fieldPath is invalid, see WithTrimE.
 func (d *Differ) WithTrim(fieldPath string, cutset string) *Differ {
-	d.trimTags = append(d.trimTags, newTrimTag(fieldPath, cutset))
+	mustSuccess(func() error {
+		return d.WithTrimE(fieldPath, cutset)
+	})
 	return d
 }
 
+// WithTrimE is like WithTrim but returns an *InvalidRuleError instead of panicking.
+func (d *Differ) WithTrimE(fieldPath string, cutset string) error {
+	tt, err := newTrimTag(fieldPath, cutset)
+	if err != nil {
+		return err
+	}
+	d.trimTags = append(d.trimTags, tt)
+	d.plans = nil
+	return nil
+}
+
 // WithTrimSpace trim space before comparison.
+// It panics with an *InvalidRuleError if any of the fieldPaths is invalid, see WithTrimSpaceE.
 func (d *Differ) WithTrimSpace(fieldPaths ...string) *Differ {
-	for _, exp := range fieldPaths {
-		d.trimSpaces = append(d.trimSpaces, regexp.MustCompile(exp))
+	mustSuccess(func() error {
+		return d.WithTrimSpaceE(fieldPaths...)
+	})
+	return d
+}
+
+// WithTrimSpaceE is like WithTrimSpace but returns an *InvalidRuleError instead of panicking.
+func (d *Differ) WithTrimSpaceE(fieldPaths ...string) error {
+	trimSpaces, err := compileRules("trim space", fieldPaths)
+	if err != nil {
+		return err
 	}
+	d.trimSpaces = append(d.trimSpaces, trimSpaces...)
+	d.plans = nil
+	return nil
+}
+
+// WithTransform transforms the values of some fields with fn before comparison, and compares
+// the transformed values recursively with the other rules instead, such as normalizing emails
+// or parsing a JSON string into a map. Diffs found in a transformed value display the original
+// values of it as well if they are different, see Diff.Original. Patches replace a transformed
+// value as a whole. Each value is transformed at most once, by the first transformer whose
+// fieldPath matches it.
+// It panics with an *InvalidRuleError if fieldPath is invalid, see WithTransformE.
+func (d *Differ) WithTransform(fieldPath string, fn func(v interface{}) interface{}) *Differ {
+	mustSuccess(func() error {
+		return d.WithTransformE(fieldPath, fn)
+	})
 	return d
 }
 
+// WithTransformE is like WithTransform but returns an *InvalidRuleError instead of panicking.
+func (d *Differ) WithTransformE(fieldPath string, fn func(v interface{}) interface{}) error {
+	t, err := newTransform(fieldPath, fn)
+	if err != nil {
+		return err
+	}
+	d.transforms = append(d.transforms, t)
+	d.plans = nil
+	return nil
+}
+
+// WithEditScript makes Differ align the elements of some slices by their longest common subsequence,
+// so that inserted and deleted elements are reported as Added and Removed diffs instead of
+// element diffs and a length diff.
+//
+// Removed elements are reported with their index in A, while Added and changed elements
+// are reported with their index in B. Slices which need more than 1024 inserts and deletes
+// are compared by index instead, with a length diff.
+// It panics with an *InvalidRuleError if any of the fieldPaths is invalid, see WithEditScriptE.
+func (d *Differ) WithEditScript(fieldPaths ...string) *Differ {
+	mustSuccess(func() error {
+		return d.WithEditScriptE(fieldPaths...)
+	})
+	return d
+}
+
+// WithEditScriptE is like WithEditScript but returns an *InvalidRuleError instead of panicking.
+func (d *Differ) WithEditScriptE(fieldPaths ...string) error {
+	editScripts, err := compileRules("edit script", fieldPaths)
+	if err != nil {
+		return err
+	}
+	d.editScripts = append(d.editScripts, editScripts...)
+	d.plans = nil
+	return nil
+}
+
+// WithSliceKey makes Differ pair the elements of some slices by the keys returned by keyFunc
+// instead of by their indexes, the keys must be comparable and unique in each slice, otherwise
+// CompareE returns a *SliceKeyError.
+// Elements which can not be paired are reported as Removed or Added diffs, and the paths of
+// the elements use their keys, such as Person.Parents[id=42].Name where keyName is "id".
+// It panics with an *InvalidRuleError if fieldPath is invalid, see WithSliceKeyE.
+func (d *Differ) WithSliceKey(fieldPath, keyName string, keyFunc func(elem interface{}) interface{}) *Differ {
+	mustSuccess(func() error {
+		return d.WithSliceKeyE(fieldPath, keyName, keyFunc)
+	})
+	return d
+}
+
+// WithSliceKeyE is like WithSliceKey but returns an *InvalidRuleError instead of panicking.
+func (d *Differ) WithSliceKeyE(fieldPath, keyName string, keyFunc func(elem interface{}) interface{}) error {
+	sk, err := newSliceKey(fieldPath, keyName, keyFunc)
+	if err != nil {
+		return err
+	}
+	d.sliceKeys = append(d.sliceKeys, sk)
+	d.plans = nil
+	return nil
+}
+
 // FindDiff find diff with name.
-func (d *Differ) FindDiff(fieldName string) (df *diff, ok bool) {
-	df, ok = d.diffs[fieldName]
-	return
+func (d *Differ) FindDiff(fieldName string) (df *Diff, ok bool) {
+	return d.result().FindDiff(fieldName)
+}
+
+// FindDiffByPath find diff with a structured Path.
+func (d *Differ) FindDiffByPath(p Path) (df *Diff, ok bool) {
+	return d.result().FindDiffByPath(p)
 }
 
 // FindDiffFuzzily find diff with regexp.
-func (d *Differ) FindDiffFuzzily(expr string) (dfs []*diff) {
-	if r, err := regexp.Compile(expr); err == nil {
-		for name, df := range d.diffs {
-			if r.MatchString(name) {
-				dfs = append(dfs, df)
-			}
-		}
-	}
-	return
+func (d *Differ) FindDiffFuzzily(expr string) (dfs []*Diff) {
+	return d.result().FindDiffFuzzily(expr)
 }
 
+// Config returns a snapshot of the rules of d, later changes of d do not affect it.
+func (d *Differ) Config() *Config {
+	return &Config{rules: d.rules.clone()}
+}
+
+// result returns the diffs found by d so far.
+func (d *Differ) result() *Result {
+	return &Result{diffs: d.diffs, diffList: d.diffList, tmpl: d.diffTmpl}
+}
+
+// ResetDiffs clears the diffs found so far, the rules of d are kept.
+func (d *Differ) ResetDiffs() *Differ {
+	d.diffs = make(map[string]*Diff, len(d.diffs))
+	d.diffList = make([]*Diff, 0, len(d.diffList))
+	d.bff = newBufferF()
+	return d
+}
+
+// Reset clears the diffs found so far and all the rules of d.
 func (d *Differ) Reset() *Differ {
 	d.includes = make([]*regexp.Regexp, 0, len(d.includes))
 	d.ignores = make([]*regexp.Regexp, 0, len(d.ignores))
+	d.includeFuncs = make([]func(Path) bool, 0, len(d.includeFuncs))
+	d.ignoreFuncs = make([]func(Path) bool, 0, len(d.ignoreFuncs))
 	d.trimSpaces = make([]*regexp.Regexp, 0, len(d.trimSpaces))
+	d.editScripts = make([]*regexp.Regexp, 0, len(d.editScripts))
+	d.sliceKeys = make([]*sliceKey, 0, len(d.sliceKeys))
 	d.trimTags = make([]*trimTag, 0, len(d.trimTags))
+	d.transforms = make([]*transform, 0, len(d.transforms))
 	d.comparators = make([]Comparator, 0, len(d.comparators))
 	d.sorters = make([]Sorter, 0, len(d.sorters))
-	d.diffs = make(map[string]*diff, len(d.diffs))
+	d.ignoreTypes, d.typeCmps = nil, nil
+	d.plans = nil
+	d.diffs = make(map[string]*Diff, len(d.diffs))
+	d.diffList = make([]*Diff, 0, len(d.diffList))
 	d.bff = newBufferF()
 	return d
 }
 
+// Compare compares a and b and records the diffs.
+// It panics if the comparison fails, see CompareE.
 func (d *Differ) Compare(a, b interface{}) *Differ {
+	mustSuccess(func() error {
+		return d.CompareE(a, b)
+	})
+	return d
+}
+
+// CompareE is like Compare but returns an error instead of panicking.
+// The returned error is one of *TypeMismatchError, *DepthExceededError, *InvalidValueError,
+// *UnexpectedTypeError, *UnexportedFieldError and *ComparatorError,
+// all of them carry the field path where the failure happened.
+func (d *Differ) CompareE(a, b interface{}) (err error) {
+	defer catch(&err)
+	d.visitedA = make(map[visit]visitRecord)
+	d.visitedB = make(map[visit]visitRecord)
 	va, vb := ValueOf(a), ValueOf(b)
+	if !va.IsValid() || !vb.IsValid() {
+		if va.IsValid() == vb.IsValid() {
+			return nil
+		}
+		return &InvalidValueError{Path: initTypeName}
+	}
 	if va.Type() != vb.Type() {
-		typeMismatchPanic(a, b)
+		return &TypeMismatchError{Path: initTypeName, TypeA: va.Type(), TypeB: vb.Type()}
 	}
 	tName := va.Type().Name()
 	if va.Kind() == Ptr {
-		tName = va.Elem().Type().Name()
+		tName = va.Type().Elem().Name()
 	}
-	d.doCompare(va, vb, iF(isStringBlank(tName), initTypeName, tName).(string), 0)
-	return d
+	root := PathStep{kind: RootStep, name: iF(isStringBlank(tName), initTypeName, tName).(string)}
+	d.doCompare(va, vb, newStack().next(root, va, vb), 0)
+	return nil
 }
 
-func (d *Differ) doCompare(a, b Value, fieldPath string, depth int) {
+func (d *Differ) doCompare(a, b Value, path Path, depth int) {
+	p := d.planOf(path)
+	if d.isPruned(p, path) || d.isIgnoredType(a) || d.isIgnoredType(b) {
+		return
+	}
 	if depth > d.maxDepth {
-		panic("depth over limit")
+		throw(&DepthExceededError{Path: d.pathString(path), MaxDepth: d.maxDepth})
 	}
 
 	if !a.IsValid() || !b.IsValid() {
-		panic("value invalid: " + a.Type().String())
+		throw(&InvalidValueError{Path: d.pathString(path)})
 	}
 
 	if a.Type() != b.Type() {
-		typeMismatchPanic(a.Type(), b.Type())
-	}
-
-	for _, c := range d.comparators {
-		if c.Match(fieldPath) {
-			fieldPath = fieldPath + useComparatorSuffix
-			dt, va, vb := c.Equals(a.Interface(), b.Interface())
-			switch dt {
-			case LengthDiff:
-				d.setLenDiff(fieldPath, a, b)
-			case NilDiff:
-				d.setNilDiff(fieldPath, a, b)
-			case ElemDiff:
-				d.setDiff(fieldPath, va, vb)
-			case NoDiff:
-				return
-			default:
-				panic("customized comparator returned an unexpected DiffType")
-			}
-			return
-		}
+		throw(&TypeMismatchError{Path: d.pathString(path), TypeA: a.Type(), TypeB: b.Type()})
+	}
+
+	if p.transform != nil {
+		d.compareTransformed(a, b, path, p.transform, depth)
+		return
+	}
+
+	if c := d.comparatorOf(p, path, a.Type()); c != nil {
+		dt, va, vb := c.Equals(d.interfaceOf(a, path), d.interfaceOf(b, path))
+		d.setCustomDiff(path, dt, a, b, va, vb)
+		return
+	}
+
+	if d.compareByType(a, b, path) {
+		return
+	}
+
+	if d.compareByEqualMethod(a, b, path) {
+		return
 	}
 
 	switch a.Kind() {
 	case Array:
-		for i := 0; i < minInt(a.Len(), b.Len()); i++ {
-			d.doCompare(a.Index(i), b.Index(i), a.Index(i).Type().Name(), depth)
+		for i := 0; i < a.Len(); i++ {
+			ea, eb := a.Index(i), b.Index(i)
+			d.doCompare(ea, eb, path.next(PathStep{kind: IndexStep, index: i}, ea, eb), depth)
 		}
 	case Slice:
 		if a.IsNil() != b.IsNil() {
-			d.setNilDiff(fieldPath, a, b)
+			d.setNilDiff(path, a, b)
 			return
 		}
-		if a.Len() != b.Len() {
-			d.setLenDiff(fieldPath, a, b)
+		sk, sorter, editScript := p.sliceKey, d.sorterOf(p, path, a.Type()), p.editScript
+		if sk == nil {
+			sk = tagSliceKey(a.Type().Elem())
 		}
-		if a.Pointer() == b.Pointer() {
+		if sk == nil && sorter != nil {
+			path = path.sorted()
+		}
+		if a.Len() != b.Len() && !editScript && sk == nil {
+			d.setLenDiff(path, a, b)
+		}
+		if a.Pointer() == b.Pointer() && a.Len() == b.Len() {
 			return
 		}
-		for _, s := range d.sorters {
-			if s.Match(fieldPath) {
-				a, b = d.sortSlice(a, b, s)
-				break
+		if sk != nil {
+			d.compareByKey(a, b, path, sk, depth)
+			return
+		}
+		if sorter != nil {
+			d.checkInterface(a, path)
+			a, b = d.sortSlice(a, b, sorter)
+		}
+		if editScript {
+			if d.compareEditScript(a, b, path, depth) {
+				return
+			}
+			if a.Len() != b.Len() {
+				d.setLenDiff(path, a, b)
 			}
 		}
 		for i := 0; i < minInt(a.Len(), b.Len()); i++ {
-			d.doCompare(a.Index(i), b.Index(i),
-				concat(fieldPath, "[", strconv.Itoa(i), "]"), depth)
+			ea, eb := a.Index(i), b.Index(i)
+			d.doCompare(ea, eb, path.next(PathStep{kind: IndexStep, index: i}, ea, eb), depth)
 		}
 	case Interface:
 		if a.IsNil() != b.IsNil() {
-			d.setNilDiff(fieldPath, a, b)
+			d.setNilDiff(path, a, b)
+			return
+		}
+		if a.IsNil() {
+			return
+		}
+
+		d.checkInterface(a, path)
+
+		if a.Elem().Type() != b.Elem().Type() {
+			d.setDiff(path, a, b)
+			return
+		}
+
+		if na, nb, ok := parseNumberValue(a, b); ok {
+			if !isNumberEqual(na.Interface().(json.Number), nb.Interface().(json.Number)) {
+				d.setDiff(path.next(PathStep{kind: TypeAssertStep}, na, nb), na, nb)
+			}
 			return
 		}
 
 		if sa, sb, ok := parseStringValue(a, b); ok {
-			d.doCompare(sa, sb, fieldPath, depth)
+			d.doCompare(sa, sb, path.next(PathStep{kind: TypeAssertStep}, sa, sb), depth)
 			return
 		}
 
 		if fa, fb, ok := parseFloatValue(a, b); ok {
-			d.doCompare(fa, fb, fieldPath, depth)
+			d.doCompare(fa, fb, path.next(PathStep{kind: TypeAssertStep}, fa, fb), depth)
 			return
 		}
 
 		if ba, bb, ok := parseBoolValue(a, b); ok {
-			d.doCompare(ba, bb, fieldPath, depth)
+			d.doCompare(ba, bb, path.next(PathStep{kind: TypeAssertStep}, ba, bb), depth)
 			return
 		}
 
 		if aa, ab, ok := parseArrayValue(a, b); ok {
-			d.doCompare(aa, ab, fieldPath, depth)
+			d.doCompare(aa, ab, path.next(PathStep{kind: TypeAssertStep}, aa, ab), depth)
 			return
 		}
 
 		if ma, mb, ok := parseMapValue(a, b); ok {
-			d.doCompare(ma, mb, fieldPath, depth+1)
+			d.doCompare(ma, mb, path.next(PathStep{kind: TypeAssertStep}, ma, mb), depth+1)
 			return
 		}
 
-		panic(fmt.Sprintf("unexpected interface with type: %s", a.Type().Name()))
+		throw(&UnexpectedTypeError{Path: d.pathString(path), Type: a.Elem().Type()})
 
 	case Ptr:
 		if a.IsNil() != b.IsNil() {
-			d.setNilDiff(fieldPath, a, b)
+			d.setNilDiff(path, a, b)
+			return
+		}
+		if a.Pointer() == b.Pointer() {
 			return
 		}
-		if a.Pointer() != b.Pointer() {
-			d.doCompare(a.Elem(), b.Elem(), fieldPath, depth)
+		if d.isCycle(a, b, path) {
+			return
 		}
+		ka, kb := visit{a.Type(), a.Pointer()}, visit{b.Type(), b.Pointer()}
+		d.visitedA[ka] = visitRecord{peer: kb.ptr, pathLen: len(path)}
+		d.visitedB[kb] = visitRecord{peer: ka.ptr, pathLen: len(path)}
+		ea, eb := a.Elem(), b.Elem()
+		d.doCompare(ea, eb, path.next(PathStep{kind: PtrStep}, ea, eb), depth+1)
+		delete(d.visitedA, ka)
+		delete(d.visitedB, kb)
 	case Struct:
-		for i, n := 0, a.NumField(); i < n; i++ {
-			d.doCompare(a.Field(i), b.Field(i), concat(fieldPath, ".", a.Type().Field(i).Name), depth+1)
+		if d.unexported == allowUnexported {
+			a, b = addressableValue(a), addressableValue(b)
+		}
+		for i, field := range structFields(a.Type()) {
+			fa, fb := a.Field(i), b.Field(i)
+			if field.PkgPath != "" {
+				switch d.unexported {
+				case ignoreUnexported:
+					continue
+				case allowUnexported:
+					fa, fb = exportValue(fa), exportValue(fb)
+				}
+			}
+			d.doCompare(fa, fb, path.next(PathStep{kind: FieldStep, name: field.Name, index: i, owner: a.Type()}, fa, fb), depth+1)
 		}
 	case Map:
 		if a.IsNil() != b.IsNil() {
-			d.setNilDiff(fieldPath, a, b)
+			d.setNilDiff(path, a, b)
 			return
 		}
 		if a.Len() != b.Len() {
-			d.setLenDiff(fieldPath, a, b)
+			d.setLenDiff(path, a, b)
 		}
-		for _, k := range a.MapKeys() {
+		for _, k := range unionMapKeys(a, b) {
 			v1, v2 := a.MapIndex(k), b.MapIndex(k)
-			d.doCompare(v1, v2, concat(fieldPath, "[", toString(k.Interface()), "]"), depth)
-		}
-	case String:
-		for _, ts := range d.trimSpaces {
-			if ts.MatchString(fieldPath) {
-				if !DeepEqual(strings.TrimSpace(a.String()), strings.TrimSpace(b.String())) {
-					d.setDiff(fieldPath, a, b)
-				}
-				return
+			keyPath := path.next(PathStep{kind: MapKeyStep, key: valueInterface(k)}, v1, v2)
+			switch {
+			case !v1.IsValid(), !v2.IsValid():
+				d.setMissingDiff(keyPath, v1, v2)
+			default:
+				d.doCompare(v1, v2, keyPath, depth)
 			}
 		}
-		for _, tt := range d.trimTags {
-			if tt.fieldRegexp.MatchString(fieldPath) {
-				if !DeepEqual(tt.Trim(a.String()), tt.Trim(b.String())) {
-					d.setDiff(fieldPath, a, b)
-				}
-				return
+	case String:
+		if p.trim != nil {
+			if p.trim(a.String()) != p.trim(b.String()) {
+				d.setDiff(path, a, b)
 			}
+			return
 		}
 		fallthrough
 	default:
-		if !DeepEqual(a.Interface(), b.Interface()) {
-			d.setDiff(fieldPath, a, b)
+		if p.tolerance > 0 && (a.Kind() == Float32 || a.Kind() == Float64) {
+			if math.Abs(a.Float()-b.Float()) > p.tolerance {
+				d.setDiff(path, a, b)
+			}
 			return
 		}
+		if !DeepEqual(d.interfaceOf(a, path), d.interfaceOf(b, path)) {
+			d.setDiff(path, a, b)
+			return
+		}
+	}
+}
+
+// isEqual checks if a and b are equal without recording any diff.
+func (d *Differ) isEqual(a, b Value, path Path, depth int) bool {
+	diffs, diffList, sink := d.diffs, d.diffList, d.sink
+	d.diffs, d.diffList, d.sink = make(map[string]*Diff), nil, nil
+	defer func() {
+		d.diffs, d.diffList, d.sink = diffs, diffList, sink
+	}()
+	d.doCompare(a, b, path, depth)
+	return len(d.diffList) == 0
+}
+
+// isCycle checks if either of the pointers a and b points back to one of their ancestors,
+// a diff will be recorded if only one of them does, or they point back to different ancestors.
+func (d *Differ) isCycle(a, b Value, path Path) bool {
+	ra, okA := d.visitedA[visit{a.Type(), a.Pointer()}]
+	rb, okB := d.visitedB[visit{b.Type(), b.Pointer()}]
+	if !okA && !okB {
+		return false
+	}
+	if !okA || !okB || ra.peer != b.Pointer() || rb.peer != a.Pointer() {
+		d.setCycleDiff(path, ra, rb, okA, okB)
 	}
+	return true
 }
 
 func (d *Differ) sortSlice(sa, sb Value, sorter Sorter) (sortedSa, sortedSb Value) {
@@ -325,56 +742,244 @@ func (d *Differ) sortSlice(sa, sb Value, sorter Sorter) (sortedSa, sortedSb Valu
 	return
 }
 
-func (d *Differ) setNilDiff(fieldName string, a, b Value) {
-	d.setDiff(fieldName, iF(a.IsNil(), null, notNull), iF(b.IsNil(), null, notNull))
+func (d *Differ) setNilDiff(path Path, a, b Value) {
+	d.addDiff(newDiff(NilMismatch, path, d.pathString(path), a.Type(), valueInterface(a), valueInterface(b),
+		iF(a.IsNil(), null, notNull), iF(b.IsNil(), null, notNull)))
 }
 
-func (d *Differ) setLenDiff(fieldName string, a, b Value) {
-	d.setDiff(fieldName+"[Length]", a.Len(), b.Len())
+// setMissingDiff records an Added or Removed diff, the invalid one of a and b is the missing one.
+func (d *Differ) setMissingDiff(path Path, a, b Value) {
+	if d.isIgnoredType(a) || d.isIgnoredType(b) {
+		return
+	}
+	if !a.IsValid() {
+		d.addDiff(newDiff(Added, path, d.pathString(path), b.Type(), nil, valueInterface(b), missing, valueInterface(b)))
+		return
+	}
+	d.addDiff(newDiff(Removed, path, d.pathString(path), a.Type(), valueInterface(a), nil, valueInterface(a), missing))
+}
+
+func (d *Differ) setCycleDiff(path Path, ra, rb visitRecord, okA, okB bool) {
+	d.addDiff(newDiff(CycleMismatch, path, d.pathString(path), path.Last().Type(), nil, nil,
+		iF(okA, concat(cyclePrefix, d.pathString(path[:ra.pathLen]), ">"), noCycle),
+		iF(okB, concat(cyclePrefix, d.pathString(path[:rb.pathLen]), ">"), noCycle)))
+}
+
+func (d *Differ) setLenDiff(path Path, a, b Value) {
+	if d.ignoreTypes[a.Type().Elem()] {
+		return
+	}
+	d.addDiff(newDiff(LengthMismatch, path, d.pathString(path)+"[Length]", a.Type(),
+		valueInterface(a), valueInterface(b), a.Len(), b.Len()))
 }
 
-func (d *Differ) setDiff(fieldName string, va, vb interface{}) {
+// setCustomDiff records the diff reported by a customized Comparator.
+func (d *Differ) setCustomDiff(path Path, dt DiffType, a, b Value, va, vb interface{}) {
+	name := d.pathString(path) + useComparatorSuffix
+	switch dt {
+	case LengthDiff:
+		name, va, vb = name+"[Length]", a.Len(), b.Len()
+	case NilDiff:
+		va, vb = iF(a.IsNil(), null, notNull), iF(b.IsNil(), null, notNull)
+	case ElemDiff:
+	case NoDiff:
+		return
+	default:
+		throw(&ComparatorError{Path: d.pathString(path), DiffType: dt})
+	}
+	d.addDiff(newDiff(CustomComparator, path, name, a.Type(), valueInterface(a), valueInterface(b), va, vb))
+}
+
+func (d *Differ) setDiff(path Path, a, b Value) {
+	va, vb := valueInterface(a), valueInterface(b)
+	d.addDiff(newDiff(Changed, path, d.pathString(path), a.Type(), va, vb, va, vb))
+}
+
+func (d *Differ) addDiff(df *Diff) {
 	switch d.getDiffMode() {
 	case includeMode:
-		if !d.isIncludedField(fieldName) {
+		if !d.isIncludedField(df) && (d.postFilter || !d.hasIncludedAncestor(df.path)) {
 			return
 		}
 	case ignoreMode:
-		if d.isIgnoredField(fieldName) {
+		if d.isIgnoredField(df) {
 			return
 		}
 	}
-	d.diffs[fieldName] = newDiff(fieldName, va, vb)
+	df.showOriginal()
+	if d.sink != nil {
+		d.sink(df)
+		return
+	}
+	old, ok := d.diffs[df.name]
+	switch {
+	case !ok:
+		d.diffs[df.name] = df
+	case old.kind == df.kind:
+		*old = *df
+		return
+	}
+	// an Added and a Removed diff may share the same name in an edit script.
+	d.diffList = append(d.diffList, df)
 }
 
 func (d *Differ) getDiffMode() diffMode {
-	if len(d.includes) > 0 {
+	if len(d.includes) > 0 || len(d.includeFuncs) > 0 {
 		return includeMode
 	}
-	if len(d.ignores) > 0 {
+	if len(d.ignores) > 0 || len(d.ignoreFuncs) > 0 {
 		return ignoreMode
 	}
 	return allDiffMode
 }
 
-func (d *Differ) isIncludedField(fieldName string) bool {
+func (d *Differ) isIncludedField(df *Diff) bool {
 	for _, ic := range d.includes {
-		if ic.MatchString(fieldName) {
+		if ic.MatchString(df.name) {
+			return true
+		}
+	}
+	for _, fn := range d.includeFuncs {
+		if fn(df.path) {
 			return true
 		}
 	}
 	return false
 }
 
-func (d *Differ) isIgnoredField(fieldName string) bool {
+// compareByType compares a and b with the comparator set by WithTypeComparator for their type,
+// or for the type of the values held by them, ok is false if there is no such comparator.
+func (d *Differ) compareByType(a, b Value, path Path) (ok bool) {
+	if len(d.typeCmps) == 0 {
+		return false
+	}
+	if a.Kind() == Interface && !a.IsNil() && !b.IsNil() && a.Elem().Type() == b.Elem().Type() {
+		if _, ok = d.typeCmps[a.Elem().Type()]; ok {
+			ea, eb := a.Elem(), b.Elem()
+			return d.compareByType(ea, eb, path.next(PathStep{kind: TypeAssertStep}, ea, eb))
+		}
+	}
+	fn, ok := d.typeCmps[a.Type()]
+	if ok && !fn(d.interfaceOf(a, path), d.interfaceOf(b, path)) {
+		d.setDiff(path, a, b)
+	}
+	return ok
+}
+
+// isIgnoredType checks if v or the value held by v is of a type set by IgnoreTypes.
+func (d *Differ) isIgnoredType(v Value) bool {
+	if len(d.ignoreTypes) == 0 || !v.IsValid() {
+		return false
+	}
+	if v.Kind() == Interface && !v.IsNil() && d.ignoreTypes[v.Elem().Type()] {
+		return true
+	}
+	return d.ignoreTypes[v.Type()]
+}
+
+// isPruned checks if the traversal of path should be skipped, since no diff of the subtree
+// can pass the ignore or include rules.
+func (d *Differ) isPruned(p *plan, path Path) bool {
+	if p.direct && p.tag != nil && p.tag.ignore {
+		return true
+	}
+	if d.postFilter {
+		return false
+	}
+	switch d.getDiffMode() {
+	case includeMode:
+		return p.excluded && len(d.includeFuncs) == 0
+	case ignoreMode:
+		if p.ignored {
+			return true
+		}
+		for _, fn := range d.ignoreFuncs {
+			if fn(path.clip()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasIncludedAncestor checks if path or one of its ancestors matches an include rule.
+func (d *Differ) hasIncludedAncestor(path Path) bool {
+	if d.planOf(path).included {
+		return true
+	}
+	for i := 1; i <= len(path); i++ {
+		for _, fn := range d.includeFuncs {
+			if fn(path[:i:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *Differ) isIgnoredField(df *Diff) bool {
 	for _, ig := range d.ignores {
-		if ig.MatchString(fieldName) {
+		if ig.MatchString(df.name) {
+			return true
+		}
+	}
+	for _, fn := range d.ignoreFuncs {
+		if fn(df.path) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(regexps []*regexp.Regexp, fieldPath string) bool {
+	for _, r := range regexps {
+		if r.MatchString(fieldPath) {
 			return true
 		}
 	}
 	return false
 }
 
-func typeMismatchPanic(a, b interface{}) {
-	panic("type mismatch: " + newDiff("type", a, b).String())
+// pathString returns the string form of path which rules match against and diffs are named by,
+// it's the JSON Pointer of path when comparing JSON documents.
+func (d *Differ) pathString(path Path) string {
+	if p := path.Last().plan; p != nil && !p.generic {
+		return p.fieldPath
+	}
+	if d.jsonPaths {
+		return path.JSONPointer()
+	}
+	return path.String()
+}
+
+// checkInterface throws an *UnexportedFieldError if v is obtained from an unexported field.
+func (d *Differ) checkInterface(v Value, path Path) {
+	if !v.CanInterface() {
+		throw(&UnexportedFieldError{Path: d.pathString(path)})
+	}
+}
+
+func (d *Differ) interfaceOf(v Value, path Path) interface{} {
+	d.checkInterface(v, path)
+	return v.Interface()
+}
+
+// valueInterface returns the raw value of v for a Diff.
+func valueInterface(v Value) interface{} {
+	if v.CanInterface() {
+		return v.Interface()
+	}
+	return v
+}
+
+func compileRules(rule string, exprs []string) ([]*regexp.Regexp, error) {
+	regexps := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		r, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, &InvalidRuleError{Rule: rule, Expr: expr, Err: err}
+		}
+		regexps = append(regexps, r)
+	}
+	return regexps, nil
 }
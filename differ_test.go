@@ -2,10 +2,17 @@ package sdiffer
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -200,6 +207,1440 @@ func (suite *DiffTestSuite) TestRawInterfaceCompare() {
 	fmt.Println(NewDiffer().Compare(any, any2).String())
 }
 
+func (suite *DiffTestSuite) TestCompareE() {
+	var tmErr *TypeMismatchError
+	err := NewDiffer().CompareE([]int{1}, []int64{1})
+	suite.True(errors.As(err, &tmErr))
+	suite.Equal(reflect.TypeOf([]int{}), tmErr.TypeA)
+
+	var deErr *DepthExceededError
+	loc := &Location{"Ji'An", &Location{"JiangXi", newLoc("China")}}
+	err = NewDiffer().WithMaxDepth(3).CompareE(loc, &Location{"Ji'An", &Location{"JiangXi", newLoc("Asia")}})
+	suite.True(errors.As(err, &deErr))
+	suite.Equal("Location.Province.Name", deErr.Path)
+
+	var irErr *InvalidRuleError
+	suite.True(errors.As(NewDiffer().IgnoreE("Person.Parents[0-9"), &irErr))
+	suite.Equal("Person.Parents[0-9", irErr.Expr)
+	suite.Error(NewDiffer().IncludesE("("))
+	suite.Error(NewDiffer().WithTrimE("(", " "))
+	suite.Error(NewDiffer().WithTrimSpaceE("("))
+
+	var cErr *ComparatorError
+	err = NewDiffer().WithComparator(badComparator{}).CompareE(&Person{Name: "a"}, &Person{Name: "b"})
+	suite.True(errors.As(err, &cErr))
+	suite.Equal("Person.Name", cErr.Path)
+
+	var ivErr *InvalidValueError
+	suite.True(errors.As(NewDiffer().CompareE(nil, 1), &ivErr))
+	suite.NoError(NewDiffer().CompareE(nil, nil))
+
+	differ := NewDiffer()
+	suite.NoError(differ.CompareE((*Location)(nil), (*Location)(nil)))
+	suite.Empty(differ.Diffs())
+	suite.NoError(differ.CompareE((*Location)(nil), &Location{}))
+	df, ok := differ.FindDiff("Location")
+	suite.True(ok)
+	suite.Equal(NilMismatch, df.Kind())
+}
+
+type badComparator struct{}
+
+func (badComparator) Match(path string) bool {
+	return path == "Person.Name"
+}
+
+func (badComparator) Equals(a, b interface{}) (dt DiffType, msgA, msgB interface{}) {
+	return DiffType(-1), nil, nil
+}
+
+func (suite *DiffTestSuite) TestMapKeys() {
+	b1 := &Building{map[string]string{"1": "1", "2": "2"}}
+	b2 := &Building{map[string]string{"1": "1", "3": "3"}}
+	for _, differ := range []*Differ{NewDiffer().Compare(b1, b2), NewDiffer().Compare(b2, b1)} {
+		suite.Len(differ.Diffs(), 2)
+		_, ok := differ.FindDiff("Building.BuildingMap[2]")
+		suite.True(ok)
+		_, ok = differ.FindDiff("Building.BuildingMap[3]")
+		suite.True(ok)
+	}
+	df, _ := NewDiffer().Compare(b1, b2).FindDiff("Building.BuildingMap[3]")
+	suite.Equal(missing, df.Va())
+	suite.Equal("3", toString(df.Vb()))
+}
+
+func (suite *DiffTestSuite) TestUnexported() {
+	type secret struct {
+		Name  string
+		token string
+		tags  map[string]string
+		inner *Location
+	}
+	type account struct {
+		secrets []secret
+		owner   secret
+	}
+	a := account{
+		secrets: []secret{{Name: "s", token: "t1", tags: map[string]string{"k": "v1"}}},
+		owner:   secret{Name: "o", inner: newLoc("a")},
+	}
+	b := account{
+		secrets: []secret{{Name: "s", token: "t2", tags: map[string]string{"k": "v2"}}},
+		owner:   secret{Name: "o", inner: newLoc("b")},
+	}
+
+	var ufErr *UnexportedFieldError
+	suite.True(errors.As(NewDiffer().CompareE(a, b), &ufErr))
+
+	differ := NewDiffer().AllowUnexported().Compare(a, b)
+	suite.Len(differ.Diffs(), 3)
+	df, ok := differ.FindDiff("account.secrets[0].token")
+	suite.True(ok)
+	suite.Equal("t1", toString(df.Va()))
+	_, ok = differ.FindDiff("account.secrets[0].tags[k]")
+	suite.True(ok)
+	_, ok = differ.FindDiff("account.owner.inner.Name")
+	suite.True(ok)
+
+	suite.Empty(NewDiffer().IgnoreUnexported().Compare(a, b).Diffs())
+}
+
+type node struct {
+	Val  int
+	Prev *node
+	Next *node
+}
+
+func newRing(vals ...int) *node {
+	head := &node{Val: vals[0]}
+	cur := head
+	for _, v := range vals[1:] {
+		cur.Next = &node{Val: v, Prev: cur}
+		cur = cur.Next
+	}
+	cur.Next, head.Prev = head, cur
+	return head
+}
+
+func (suite *DiffTestSuite) TestCycle() {
+	suite.Empty(NewDiffer().Compare(newRing(1, 2, 3), newRing(1, 2, 3)).Diffs())
+
+	differ := NewDiffer().Compare(newRing(1, 2, 3), newRing(1, 5, 3))
+	suite.NotEmpty(differ.Diffs())
+	_, ok := differ.FindDiff("node.Next.Val")
+	suite.True(ok)
+
+	// a cycles back to its head after 2 nodes while b is a 3-node ring.
+	differ = NewDiffer().Compare(newRing(1, 2), newRing(1, 2, 1))
+	df, ok := differ.FindDiff("node.Next.Next")
+	suite.True(ok)
+	suite.Equal("<cycle to node>", df.Va())
+	suite.Equal(noCycle, df.Vb())
+}
+
+func (suite *DiffTestSuite) TestDiffKind() {
+	me := &Person{Name: "me", Loc: newLoc("a"), StrArr: []string{"a"}, Parents: []*Person{{Name: "p"}}}
+	he := &Person{Name: "he", StrArr: []string{"a", "b"}, Parents: []*Person{{Name: "q"}}}
+	differ := NewDiffer().WithComparator(new(parentsComparator)).Compare(me, he)
+
+	kinds := make(map[string]DiffKind)
+	for _, df := range differ.Diffs() {
+		kinds[df.Name()] = df.Kind()
+	}
+	suite.Equal(map[string]DiffKind{
+		"Person.Name":                  Changed,
+		"Person.Loc":                   NilMismatch,
+		"Person.StrArr[Length]":        LengthMismatch,
+		"Person.Parents.$[customized]": CustomComparator,
+	}, kinds)
+
+	df, _ := differ.FindDiff("Person.StrArr[Length]")
+	suite.Equal("Person.StrArr", df.Path().String())
+	suite.Equal(reflect.TypeOf([]string{}), df.Type())
+	suite.Equal([]string{"a", "b"}, df.B())
+	suite.Equal(2, df.Vb())
+
+	df, _ = differ.FindDiff("Person.Loc")
+	suite.Equal(newLoc("a"), df.A())
+	suite.Nil(df.B())
+
+	b1 := &Building{map[string]string{"a.b": "1"}}
+	df, _ = NewDiffer().Compare(b1, &Building{}).FindDiff("Building.BuildingMap")
+	suite.Equal(NilMismatch, df.Kind())
+	df, _ = NewDiffer().Compare(b1, &Building{map[string]string{}}).FindDiff(`Building.BuildingMap[a\.b]`)
+	suite.Equal(Removed, df.Kind())
+	suite.Equal("Building.BuildingMap", df.Tag())
+	suite.Equal("1", df.Va())
+	suite.Equal("<missing>", df.Vb())
+}
+
+type locPathComparator struct{}
+
+func (locPathComparator) Match(string) bool {
+	return false
+}
+
+func (locPathComparator) MatchPath(p Path) bool {
+	return p.Last().Type() == reflect.TypeOf(&Location{}) && p.Last().Kind() == FieldStep
+}
+
+func (locPathComparator) Equals(a, b interface{}) (dt DiffType, msgA, msgB interface{}) {
+	return ElemDiff, a.(*Location).Name, b.(*Location).Name
+}
+
+func (suite *DiffTestSuite) TestPath() {
+	b1 := &Building{map[string]string{"a.b]": "1", "c": "1"}}
+	b2 := &Building{map[string]string{"a.b]": "2", "c": "2"}}
+	differ := NewDiffer().Compare(b1, b2)
+	df, ok := differ.FindDiffByPath(NewPath("Building").Field("BuildingMap").Key("a.b]"))
+	suite.True(ok)
+	suite.Equal(`Building.BuildingMap[a\.b\]]`, df.Name())
+	suite.Equal(MapKeyStep, df.Path().Last().Kind())
+	suite.Equal("a.b]", df.Path().Last().Key())
+	va, vb := df.Path().Last().Values()
+	suite.Equal("1", va.String())
+	suite.Equal("2", vb.String())
+
+	differ = NewDiffer().IgnorePath(func(p Path) bool {
+		return p.Last().Kind() == MapKeyStep && p.Last().Key() == "c"
+	}).Compare(b1, b2)
+	suite.Len(differ.Diffs(), 1)
+
+	me := &Person{Name: "me", Loc: newLoc("a"), Parents: []*Person{{Loc: newLoc("b")}}}
+	he := &Person{Name: "me", Loc: newLoc("c"), Parents: []*Person{{Loc: newLoc("d")}}}
+	differ = NewDiffer().WithComparator(locPathComparator{}).Compare(me, he)
+	suite.Len(differ.Diffs(), 2)
+	df, ok = differ.FindDiffByPath(NewPath("Person").Field("Parents").Index(0).Field("Loc"))
+	suite.True(ok)
+	suite.Equal(CustomComparator, df.Kind())
+	suite.Equal("b", df.Va())
+}
+
+func (suite *DiffTestSuite) TestEditScript() {
+	long := make([]string, 1000)
+	for i := range long {
+		long[i] = strconv.Itoa(i)
+	}
+	me := &Person{StrArr: long}
+	he := &Person{StrArr: append([]string{"new"}, long...)}
+
+	suite.Len(NewDiffer().Compare(me, he).Diffs(), 1001)
+
+	differ := NewDiffer().WithEditScript("Person.StrArr").Compare(me, he)
+	suite.Len(differ.Diffs(), 1)
+	df, ok := differ.FindDiff("Person.StrArr[0]")
+	suite.True(ok)
+	suite.Equal(Added, df.Kind())
+	suite.Equal("new", df.B())
+	suite.Equal("new", df.Vb())
+
+	me.StrArr = []string{"a", "b", "c", "d", "e"}
+	he.StrArr = []string{"a", "x", "c", "e", "f"}
+	differ = NewDiffer().WithEditScript("Person.StrArr").Compare(me, he)
+	kinds := make(map[string]DiffKind)
+	for _, df := range differ.Diffs() {
+		kinds[df.Name()] = df.Kind()
+	}
+	suite.Equal(map[string]DiffKind{
+		"Person.StrArr[1]": Changed,
+		"Person.StrArr[3]": Removed,
+		"Person.StrArr[4]": Added,
+	}, kinds)
+	df, _ = differ.FindDiff("Person.StrArr[3]")
+	suite.Equal("d", df.A())
+
+	me.StrArr = []string{"p", "q", "r", "a"}
+	he.StrArr = []string{"a", "s", "t"}
+	differ = NewDiffer().WithEditScript("Person.StrArr").Compare(me, he)
+	suite.Len(differ.Diffs(), 5)
+
+	// too many edits, the slices are compared by index instead.
+	me.StrArr, he.StrArr = make([]string, 600), make([]string, 650)
+	for i := range me.StrArr {
+		me.StrArr[i] = "a" + strconv.Itoa(i)
+	}
+	for i := range he.StrArr {
+		he.StrArr[i] = "b" + strconv.Itoa(i)
+	}
+	differ = NewDiffer().WithEditScript("Person.StrArr").Compare(me, he)
+	suite.Len(differ.Diffs(), 601)
+	_, ok = differ.FindDiff("Person.StrArr[Length]")
+	suite.True(ok)
+
+	suite.Error(NewDiffer().WithEditScriptE("("))
+}
+
+func (suite *DiffTestSuite) TestMyersEditScript() {
+	rnd := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		a, b := make([]int, rnd.Intn(20)), make([]int, rnd.Intn(20))
+		for i := range a {
+			a[i] = rnd.Intn(4)
+		}
+		for j := range b {
+			b[j] = rnd.Intn(4)
+		}
+		ops, ok := myersEditScript(len(a), len(b), func(i, j int) bool {
+			return a[i] == b[j]
+		})
+		suite.True(ok)
+
+		// the script turns a into b with the fewest edits.
+		var got []int
+		edits := 0
+		for _, op := range ops {
+			switch op.kind {
+			case editKeep:
+				suite.Equal(a[op.i], b[op.j])
+				got = append(got, a[op.i])
+			case editInsert:
+				got = append(got, b[op.j])
+				edits++
+			case editDelete:
+				edits++
+			}
+		}
+		suite.Equal(len(b), len(got))
+		if len(b) > 0 {
+			suite.Equal(b, got)
+		}
+		suite.Equal(len(a)+len(b)-2*lcsLength(a, b), edits)
+	}
+}
+
+func lcsLength(a, b []int) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] > lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	return lcs[0][0]
+}
+
+func (suite *DiffTestSuite) TestSliceKey() {
+	me := &Person{Parents: []*Person{{Name: "p1", Age: 30}, {Name: "p2", Age: 40}, {Name: "p3", Age: 50}}}
+	he := &Person{Parents: []*Person{{Name: "p4", Age: 60}, {Name: "p3", Age: 51}, {Name: "p1", Age: 30}}}
+	differ := NewDiffer().WithSliceKey("Person.Parents", "name", func(elem interface{}) interface{} {
+		return elem.(*Person).Name
+	}).Compare(me, he)
+
+	kinds := make(map[string]DiffKind)
+	for _, df := range differ.Diffs() {
+		kinds[df.Name()] = df.Kind()
+	}
+	suite.Equal(map[string]DiffKind{
+		"Person.Parents[name=p2]":     Removed,
+		"Person.Parents[name=p3].Age": Changed,
+		"Person.Parents[name=p4]":     Added,
+	}, kinds)
+	df, ok := differ.FindDiffByPath(NewPath("Person").Field("Parents").SliceKey("name", "p3").Field("Age"))
+	suite.True(ok)
+	suite.Equal(51, df.B())
+	suite.Equal(1, df.Path()[3].Index())
+
+	he.Parents[0].Name = "p3"
+	err := NewDiffer().WithSliceKey("Person.Parents", "name", func(elem interface{}) interface{} {
+		return elem.(*Person).Name
+	}).CompareE(me, he)
+	var keyErr *SliceKeyError
+	suite.True(errors.As(err, &keyErr))
+	suite.Equal("p3", keyErr.Key)
+	suite.Equal("Person.Parents", keyErr.Path)
+
+	err = NewDiffer().WithSliceKey("Person.Parents", "name", func(elem interface{}) interface{} {
+		return []string{elem.(*Person).Name}
+	}).CompareE(me, he)
+	suite.True(errors.As(err, &keyErr))
+
+	suite.Error(NewDiffer().WithSliceKeyE("(", "name", nil))
+}
+
+type Profile struct {
+	ID     int               `json:"id"`
+	Email  string            `json:"email,omitempty"`
+	Tags   []string          `json:"tags"`
+	Attrs  map[string]string `json:"attrs"`
+	Home   *Location         `json:"home"`
+	Secret string            `json:"-"`
+}
+
+func (suite *DiffTestSuite) TestJSONPatch() {
+	p1 := &Profile{
+		ID:     1,
+		Email:  "a@x.com",
+		Tags:   []string{"a", "b", "c"},
+		Attrs:  map[string]string{"a/b": "1", "c": "2"},
+		Home:   newLoc("a"),
+		Secret: "s1",
+	}
+	p2 := &Profile{
+		ID:     1,
+		Email:  "b@x.com",
+		Tags:   []string{"x", "b"},
+		Attrs:  map[string]string{"c": "3", "d": "4"},
+		Secret: "s2",
+	}
+	patch, err := NewDiffer().Compare(p1, p2).JSONPatch()
+	suite.NoError(err)
+	suite.JSONEq(`[
+		{"op":"replace","path":"/email","value":"b@x.com"},
+		{"op":"replace","path":"/home","value":null},
+		{"op":"remove","path":"/tags/2"},
+		{"op":"remove","path":"/attrs/a~1b"},
+		{"op":"add","path":"/attrs/d","value":"4"},
+		{"op":"replace","path":"/tags/0","value":"x"},
+		{"op":"replace","path":"/attrs/c","value":"3"}
+	]`, string(patch))
+
+	me := &Person{Parents: []*Person{{Name: "p1", Age: 30}, {Name: "p2", Age: 40}}}
+	he := &Person{Parents: []*Person{{Name: "p2", Age: 40}, {Name: "p1", Age: 31}}}
+	ops := NewDiffer().WithSorter(&pSorter{regexp.MustCompile("Person.Parents")}).Compare(me, he).PatchOps()
+	suite.Len(ops, 1)
+	suite.Equal("/Parents", ops[0].Path)
+	suite.Equal(he.Parents, ops[0].Value)
+
+	// the value compared by a comparator is replaced by B as a whole.
+	ops = NewDiffer().WithComparator(new(parentsComparator)).Compare(me, he).PatchOps()
+	suite.Equal([]PatchOp{{Op: opReplace, Path: "/Parents", Value: he.Parents}}, ops)
+
+	// diffs of pointer cycles and unexported fields are dropped.
+	ops = NewDiffer().Compare(newRing(1, 2), newRing(1, 2, 1)).PatchOps()
+	for _, op := range ops {
+		suite.NotNil(op.Value, op.Path)
+	}
+	type account struct {
+		Name  string
+		token string
+	}
+	ops = NewDiffer().AllowUnexported().Compare(account{"a", "t1"}, account{"b", "t2"}).PatchOps()
+	suite.Equal([]PatchOp{{Op: opReplace, Path: "/Name", Value: "b"}}, ops)
+}
+
+func (suite *DiffTestSuite) TestApply() {
+	p1 := &Profile{
+		ID:     1,
+		Tags:   []string{"a", "b", "c"},
+		Attrs:  map[string]string{"a/b": "1", "c": "2"},
+		Home:   &Location{"a", newLoc("b")},
+		Secret: "s1",
+	}
+	p2 := &Profile{
+		ID:     2,
+		Email:  "b@x.com",
+		Tags:   []string{"x", "b"},
+		Attrs:  map[string]string{"c": "3", "d": "4"},
+		Home:   &Location{"a", newLoc("c")},
+		Secret: "s2",
+	}
+	suite.NoError(NewDiffer().Compare(p1, p2).Apply(p1))
+	suite.Empty(NewDiffer().Compare(p1, p2).Diffs())
+	suite.Equal("c", p1.Home.Province.Name)
+
+	me := &Person{StrArr: []string{"p", "q", "r", "a", "b"}, Parents: []*Person{{Name: "p1", Age: 30}, {Name: "p2", Age: 40}}}
+	he := &Person{StrArr: []string{"a", "s", "t", "b", "u"}, Parents: []*Person{{Name: "p3", Age: 50}, {Name: "p1", Age: 31}}}
+	differ := NewDiffer().WithEditScript("Person.StrArr").
+		WithSliceKey("Person.Parents", "name", func(elem interface{}) interface{} {
+			return elem.(*Person).Name
+		})
+	suite.NoError(differ.Compare(me, he).Apply(me))
+	suite.Equal(he.StrArr, me.StrArr)
+	suite.Empty(differ.Reset().Compare(me, he).Diffs())
+
+	var raw1, raw2 interface{}
+	_ = json.Unmarshal([]byte(`{"a":[1,2,{"b":"c"}],"d":{"e":true}}`), &raw1)
+	_ = json.Unmarshal([]byte(`{"a":[1,3,{"b":"d"},4],"d":{"e":false,"f":null}}`), &raw2)
+	differ = NewDiffer().Compare(raw1, raw2)
+	suite.NoError(differ.Apply(&raw1))
+	suite.Equal(raw2, raw1)
+
+	// the patched target does not share memory with B.
+	me, he = &Person{Name: "a"}, &Person{Name: "a", Loc: newLoc("x"), StrArr: []string{"s"}}
+	suite.NoError(NewDiffer().Compare(me, he).Apply(me))
+	he.Loc.Name, he.StrArr[0] = "y", "t"
+	suite.Equal("x", me.Loc.Name)
+	suite.Equal([]string{"s"}, me.StrArr)
+
+	var aErr *ApplyError
+	suite.True(errors.As(Apply(*p1, nil), &aErr))
+	suite.True(errors.As(NewDiffer().Compare(&Profile{ID: 1}, &Profile{ID: 2}).Apply(&Location{}), &aErr))
+	path := NewPath("Person").Field("Name")
+	path[0].typ = reflect.TypeOf(Person{})
+	df := newDiff(Changed, path, "Person.Name", reflect.TypeOf(""), 65, 66, 65, 66)
+	suite.True(errors.As(Apply(&Person{}, []*Diff{df}), &aErr))
+	suite.Equal("can not assign int to string", aErr.Reason)
+}
+
+func (suite *DiffTestSuite) TestMerge() {
+	base := &Profile{
+		ID:    1,
+		Email: "a@x.com",
+		Tags:  []string{"a", "b"},
+		Attrs: map[string]string{"k1": "v1", "k2": "v2"},
+		Home:  &Location{"a", newLoc("b")},
+	}
+	ours := &Profile{
+		ID:    1,
+		Email: "ours@x.com",
+		Tags:  []string{"a", "b", "c"},
+		Attrs: map[string]string{"k1": "v1", "k2": "ours"},
+		Home:  &Location{"a", newLoc("c")},
+	}
+	theirs := &Profile{
+		ID:    2,
+		Email: "theirs@x.com",
+		Tags:  []string{"x", "b"},
+		Attrs: map[string]string{"k1": "v1", "k2": "v2", "k3": "theirs"},
+		Home:  &Location{"a", newLoc("c")},
+	}
+	merged, conflicts, err := NewDiffer().Merge(base, ours, theirs)
+	suite.NoError(err)
+
+	paths := make(map[string]*Conflict)
+	for _, c := range conflicts {
+		paths[c.Path] = c
+	}
+	suite.Len(paths, 2)
+	suite.Equal("a@x.com", paths["Profile.Email"].Base)
+	suite.Equal("ours@x.com", paths["Profile.Email"].Ours)
+	suite.Equal("theirs@x.com", paths["Profile.Email"].Theirs)
+	suite.Equal([]string{"x", "b"}, paths["Profile.Tags"].Theirs)
+
+	m := merged.(*Profile)
+	suite.Equal(&Profile{
+		ID:    2,
+		Email: "a@x.com",
+		Tags:  []string{"a", "b"},
+		Attrs: map[string]string{"k1": "v1", "k2": "ours", "k3": "theirs"},
+		Home:  &Location{"a", newLoc("c")},
+	}, m)
+	suite.Equal("b", base.Home.Province.Name)
+	suite.Equal(map[string]string{"k1": "v1", "k2": "v2"}, base.Attrs)
+
+	// the merged value does not share memory with ours or theirs.
+	base.Home, ours.Home = nil, &Location{"o", newLoc("p")}
+	merged, _, err = NewDiffer().Merge(base, ours, base)
+	suite.NoError(err)
+	ours.Home.Name, ours.Home.Province.Name = "x", "y"
+	suite.Equal(&Location{"o", newLoc("p")}, merged.(*Profile).Home)
+
+	_, _, err = NewDiffer().Merge(base, ours, &Person{})
+	suite.Error(err)
+
+	// a typed nil base is replaced as a whole.
+	x := &Profile{ID: 1, Tags: []string{"a"}}
+	merged, conflicts, err = NewDiffer().Merge((*Profile)(nil), x, x)
+	suite.NoError(err)
+	suite.Empty(conflicts)
+	suite.Equal(x, merged)
+	suite.NotSame(x, merged)
+	merged, conflicts, err = NewDiffer().Merge((*Profile)(nil), x, &Profile{ID: 2})
+	suite.NoError(err)
+	suite.Len(conflicts, 1)
+	suite.Equal("Profile", conflicts[0].Path)
+	suite.Nil(merged.(*Profile))
+
+	// a slice with a key is replaced as a whole, so changes of different elements conflict.
+	type Item struct {
+		ID   int
+		Name string
+	}
+	type Doc struct {
+		Items []Item
+	}
+	differ := NewDiffer().WithSliceKey(`^Doc\.Items$`, "ID", func(elem interface{}) interface{} {
+		return elem.(Item).ID
+	})
+	baseDoc := Doc{Items: []Item{{1, "a"}, {2, "b"}, {3, "c"}}}
+	oursDoc := Doc{Items: []Item{{1, "a"}, {3, "c"}}}
+	theirsDoc := Doc{Items: []Item{{1, "a"}, {2, "b"}, {3, "x"}}}
+	merged, conflicts, err = differ.Merge(baseDoc, oursDoc, theirsDoc)
+	suite.NoError(err)
+	suite.Len(conflicts, 1)
+	suite.Equal("Doc.Items", conflicts[0].Path)
+	suite.Equal(baseDoc, merged)
+}
+
+func (suite *DiffTestSuite) TestMergePatch() {
+	p1 := &Profile{
+		ID:     1,
+		Email:  "a@x.com",
+		Tags:   []string{"a", "b", "c"},
+		Attrs:  map[string]string{"a": "1", "c": "2"},
+		Home:   &Location{"a", newLoc("b")},
+		Secret: "s1",
+	}
+	p2 := &Profile{
+		ID:     1,
+		Tags:   []string{"a", "x", "c"},
+		Attrs:  map[string]string{"c": "3", "d": "4"},
+		Home:   &Location{"a", newLoc("c")},
+		Secret: "s2",
+	}
+	patch, err := NewDiffer().Compare(p1, p2).MergePatch()
+	suite.NoError(err)
+	suite.JSONEq(`{
+		"email": "",
+		"tags": ["a", "x", "c"],
+		"attrs": {"a": null, "c": "3", "d": "4"},
+		"home": {"Province": {"Name": "c"}}
+	}`, string(patch))
+
+	suite.NoError(ApplyMergePatch(p1, patch))
+	differ := NewDiffer().Compare(p1, p2)
+	suite.Len(differ.Diffs(), 1)
+	_, ok := differ.FindDiff("Profile.Secret")
+	suite.True(ok)
+
+	suite.NoError(ApplyMergePatch(p1, []byte(`{"home":null,"attrs":{"c":null},"id":3}`)))
+	suite.Nil(p1.Home)
+	suite.Equal(map[string]string{"d": "4"}, p1.Attrs)
+	suite.Equal(3, p1.ID)
+
+	var raw interface{} = map[string]interface{}{"a": "b", "c": map[string]interface{}{"d": "e"}}
+	suite.NoError(ApplyMergePatch(&raw, []byte(`{"a":null,"c":{"f":1}}`)))
+	suite.Equal(map[string]interface{}{"c": map[string]interface{}{"d": "e", "f": float64(1)}}, raw)
+
+	suite.Error(ApplyMergePatch(p1, []byte(`{"id":"x"}`)))
+
+	// a cycle diff is not turned into null, which would delete the member.
+	type self struct {
+		Val  int
+		Self *self
+	}
+	sa := &self{Val: 1}
+	sa.Self = sa
+	patch, err = NewDiffer().Compare(sa, &self{Val: 1, Self: &self{Val: 1}}).MergePatch()
+	suite.NoError(err)
+	suite.JSONEq(`{}`, string(patch))
+
+	// a Result generates the same patch, and can be applied as well.
+	p1 = &Profile{ID: 1, Attrs: map[string]string{"a": "1"}}
+	p2 = &Profile{ID: 2, Attrs: map[string]string{"b": "2"}}
+	result, err := NewDiffer().Config().Compare(p1, p2)
+	suite.NoError(err)
+	patch, err = result.MergePatch()
+	suite.NoError(err)
+	suite.JSONEq(`{"id": 2, "attrs": {"a": null, "b": "2"}}`, string(patch))
+	suite.NoError(result.Apply(p1))
+	suite.Equal(p2, p1)
+}
+
+func (suite *DiffTestSuite) TestCompareJSON() {
+	const (
+		a = `{"id":12345678901234567890,"price":1.0,"name":"a","tags":["x","y"],"meta":{"a/b":1,"c":null},"n":1}`
+		b = `{"id":12345678901234567891,"price":1,"name":"b","tags":["x"],"meta":{"a/b":2,"c":null,"d":true},"n":"1"}`
+	)
+	differ := NewDiffer()
+	suite.NoError(differ.CompareJSON([]byte(a), []byte(b)))
+	names := make([]string, 0)
+	for _, df := range differ.Diffs() {
+		names = append(names, df.Name())
+	}
+	suite.ElementsMatch([]string{"/id", "/meta[Length]", "/meta/a~1b", "/meta/d", "/n", "/name", "/tags[Length]"}, names)
+	df, _ := differ.FindDiff("/id")
+	suite.Equal(json.Number("12345678901234567890"), df.A())
+
+	differ = NewDiffer().Ignore("^/meta", "^/id$")
+	suite.NoError(differ.CompareJSON([]byte(a), []byte(b)))
+	suite.Len(differ.Diffs(), 3)
+
+	differ = NewDiffer()
+	suite.NoError(differ.CompareJSON([]byte(`[1,{"a":2}]`), []byte(`[1,{"a":3}]`)))
+	_, ok := differ.FindDiff("/1/a")
+	suite.True(ok)
+
+	differ = NewDiffer()
+	suite.NoError(differ.CompareJSON([]byte(`null`), []byte(`"x"`)))
+	df, ok = differ.FindDiff("")
+	suite.True(ok)
+	suite.Equal(NilMismatch, df.Kind())
+	suite.NoError(differ.Reset().CompareJSON([]byte(`null`), []byte(` null `)))
+	suite.Empty(differ.Diffs())
+
+	suite.Error(NewDiffer().CompareJSON([]byte(`{`), []byte(`{}`)))
+	suite.Error(NewDiffer().CompareJSON([]byte(`{} {}`), []byte(`{}`)))
+}
+
+func (suite *DiffTestSuite) TestCompareJSONStream() {
+	const (
+		a = `{"id":1,"name":" a ","tags":["x","y"],"meta":{"k":1,"a":1,"b":{"c":1}},"obj":{"x":1},"n":1}`
+		b = `{"id":1.0,"name":"a","tags":["x"],"meta":{"k":2,"b":{"c":2},"a":1,"z":true},"obj":[1],"n":"1","m":null}`
+	)
+	diffs := make(map[string]DiffKind)
+	differ := NewDiffer().WithTrimSpace("^/name$")
+	suite.NoError(differ.CompareJSONStream(strings.NewReader(a), strings.NewReader(b), func(df *Diff) {
+		diffs[df.Name()] = df.Kind()
+	}))
+	suite.Equal(map[string]DiffKind{
+		"/tags/1":   Removed,
+		"/meta/k":   Changed,
+		"/meta/b/c": Changed,
+		"/meta/z":   Added,
+		"/obj":      Changed,
+		"/n":        Changed,
+		"/m":        Added,
+	}, diffs)
+	suite.Empty(differ.Diffs())
+
+	count := 0
+	differ = NewDiffer().Ignore("^/meta", "^/obj$")
+	suite.NoError(differ.CompareJSONStream(strings.NewReader(a), strings.NewReader(b), func(df *Diff) {
+		count++
+	}))
+	suite.Equal(4, count)
+
+	// an ignored object is skipped as a whole, as CompareJSON does.
+	diffs = make(map[string]DiffKind)
+	differ = NewDiffer().Ignore("^/meta$", "^/obj$", "^/tags$")
+	suite.NoError(differ.CompareJSONStream(strings.NewReader(a), strings.NewReader(b), func(df *Diff) {
+		diffs[df.Name()] = df.Kind()
+	}))
+	suite.Equal(map[string]DiffKind{"/name": Changed, "/n": Changed, "/m": Added}, diffs)
+	suite.NoError(differ.CompareJSON([]byte(a), []byte(b)))
+	suite.Empty(differ.FindDiffFuzzily("^/(meta|obj|tags)"))
+
+	// buffered members are compared with the edit script, whose probes are not reported.
+	diffs = make(map[string]DiffKind)
+	differ = NewDiffer().WithEditScript("^/arr$")
+	suite.NoError(differ.CompareJSONStream(strings.NewReader(`{"x":1,"arr":["a","b","c"]}`),
+		strings.NewReader(`{"arr":["x","a","b","c"],"y":1}`), func(df *Diff) {
+			diffs[df.Name()] = df.Kind()
+		}))
+	suite.Equal(map[string]DiffKind{"/x": Removed, "/y": Added, "/arr/0": Added}, diffs)
+
+	// members are compared as they stream once their keys line up again, and ignored
+	// values are skipped by their tokens, even if they are out of order.
+	diffs = make(map[string]DiffKind)
+	differ = NewDiffer().Ignore("^/big$")
+	suite.NoError(differ.CompareJSONStream(
+		strings.NewReader(`{"x":1,"big":{"a":[1,{"b":[]}]},"k":[1,2],"z":"a"}`),
+		strings.NewReader(`{"y":1,"k":[1,3],"big":[[{}]],"z":"b"}`), func(df *Diff) {
+			diffs[df.Name()] = df.Kind()
+		}))
+	suite.Equal(map[string]DiffKind{"/x": Removed, "/y": Added, "/k/1": Changed, "/z": Changed}, diffs)
+
+	var depthErr *DepthExceededError
+	err := NewDiffer().WithMaxDepth(1).CompareJSONStream(strings.NewReader(a), strings.NewReader(b), func(*Diff) {})
+	suite.True(errors.As(err, &depthErr))
+	suite.Equal("/tags", depthErr.Path)
+
+	suite.Error(NewDiffer().CompareJSONStream(strings.NewReader(`[1,`), strings.NewReader(`[1,2]`), func(*Diff) {}))
+	suite.Error(NewDiffer().CompareJSONStream(strings.NewReader(`1 2`), strings.NewReader(`1`), func(*Diff) {}))
+}
+
+func (suite *DiffTestSuite) TestCompareNDJSON() {
+	const (
+		a = `{"id":1,"name":"a","ts":1}
+{"id":2,"name":"b","ts":2}
+{"id":3,"name":"c","ts":3}
+`
+		b = `{"id":2,"name":"b","ts":20}
+{"id":1,"name":"x","ts":10}
+
+{"id":4,"name":"d","ts":40}
+`
+	)
+	differ := NewDiffer().Ignore("^/ts$")
+	result, err := differ.CompareNDJSON(strings.NewReader(a), strings.NewReader(b), "/id")
+	suite.NoError(err)
+	suite.Equal(3, result.RecordsA)
+	suite.Equal(3, result.RecordsB)
+	suite.Equal(1, result.Equal)
+	suite.Equal(1, result.Changed)
+	suite.Equal(1, result.Added)
+	suite.Equal(1, result.Removed)
+	suite.Len(result.Records, 3)
+	rec := result.Records[0]
+	suite.Equal(Changed, rec.Kind)
+	suite.Equal(0, rec.IndexA)
+	suite.Equal(1, rec.IndexB)
+	suite.Equal(json.Number("1"), rec.Key)
+	suite.Len(rec.Diffs, 1)
+	suite.Equal("/name", rec.Diffs[0].Name())
+	suite.Equal(Removed, result.Records[1].Kind)
+	suite.Equal(-1, result.Records[1].IndexB)
+	suite.Equal(Added, result.Records[2].Kind)
+	suite.Equal(json.Number("4"), result.Records[2].Key)
+
+	// the rules are kept between records
+	result, err = differ.CompareNDJSON(strings.NewReader(a), strings.NewReader(b), "")
+	suite.NoError(err)
+	suite.Equal(0, result.Equal)
+	suite.Equal(3, result.Changed)
+	suite.Equal(0, result.Added)
+	for _, rec := range result.Records {
+		for _, df := range rec.Diffs {
+			suite.NotEqual("/ts", df.Name())
+		}
+	}
+
+	result, err = differ.CompareNDJSON(strings.NewReader(a), strings.NewReader(""), "")
+	suite.NoError(err)
+	suite.Equal(3, result.Removed)
+
+	_, err = differ.CompareNDJSON(strings.NewReader(a), strings.NewReader(b), "/missing")
+	suite.Error(err)
+	_, err = differ.CompareNDJSON(strings.NewReader(a), strings.NewReader(a+a), "/id")
+	suite.Error(err)
+	_, err = differ.CompareNDJSON(strings.NewReader(a), strings.NewReader(`{"id":`), "")
+	suite.Error(err)
+}
+
+func (suite *DiffTestSuite) TestResetDiffs() {
+	differ := NewDiffer().Ignore(`^T\.Ignored$`).WithTrimSpace(`^T\.Name$`)
+	type T struct {
+		Name    string
+		Ignored int
+	}
+	differ.Compare(T{Name: "a", Ignored: 1}, T{Name: "b", Ignored: 2})
+	suite.Len(differ.Diffs(), 1)
+	differ.ResetDiffs().Compare(T{Name: " a ", Ignored: 1}, T{Name: "a", Ignored: 2})
+	suite.Empty(differ.Diffs())
+}
+
+func (suite *DiffTestSuite) TestConfig() {
+	type T struct {
+		Name      string
+		Tags      []string
+		UpdatedAt int
+	}
+	differ := NewDiffer().Ignore(`\.UpdatedAt$`).WithTrimSpace(`\.Name$`).WithSorter(&stringSorter{})
+	config := differ.Config()
+	differ.Ignore(`\.Name$`)
+
+	var wg sync.WaitGroup
+	results := make([]*Result, 16)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			a := T{Name: "a", Tags: []string{"x", "y"}, UpdatedAt: i}
+			b := T{Name: " a ", Tags: []string{"y", "x"}, UpdatedAt: -i}
+			if i%2 == 1 {
+				b.Name = strconv.Itoa(i)
+			}
+			results[i], _ = config.Compare(a, b)
+		}(i)
+	}
+	wg.Wait()
+	for i, result := range results {
+		suite.Require().NotNil(result)
+		if i%2 == 0 {
+			suite.True(result.Equal())
+			suite.Empty(result.String())
+			continue
+		}
+		suite.Len(result.Diffs(), 1)
+		df, ok := result.FindDiff("T.Name")
+		suite.True(ok)
+		suite.Equal(strconv.Itoa(i), df.B())
+		_, ok = result.FindDiffByPath(NewPath("T").Field("Name"))
+		suite.True(ok)
+		suite.Len(result.FindDiffFuzzily(`Name$`), 1)
+		suite.Equal(fmt.Sprintf("Field: \"T.Name\", A: a, B: %d\n", i), result.String())
+	}
+
+	result, err := config.CompareJSON([]byte(`{"a":1}`), []byte(`{"a":2}`))
+	suite.NoError(err)
+	patch, err := result.JSONPatch()
+	suite.NoError(err)
+	suite.JSONEq(`[{"op":"replace","path":"/a","value":2}]`, string(patch))
+
+	_, err = config.Compare(T{}, 1)
+	suite.Error(err)
+	suite.Empty(differ.Diffs())
+}
+
+type stringSorter struct{}
+
+func (s *stringSorter) Match(fieldPath string) bool {
+	return strings.HasSuffix(fieldPath, ".Tags")
+}
+
+func (s *stringSorter) Less(a, b interface{}) bool {
+	return a.(string) < b.(string)
+}
+
+func (suite *DiffTestSuite) TestPlanCache() {
+	type Item struct {
+		Name string
+		Tags []string
+	}
+	type T struct {
+		Items []Item
+		Attrs map[string]string
+	}
+	a := T{Items: []Item{{Name: "a", Tags: []string{"x", "y"}}}, Attrs: map[string]string{"k": "v"}}
+	b := T{Items: []Item{{Name: " a ", Tags: []string{"y", "x"}}}, Attrs: map[string]string{"k": "w"}}
+
+	differ := NewDiffer()
+	differ.Compare(a, b)
+	suite.Len(differ.Diffs(), 4)
+
+	// plans compiled by the last comparison are dropped once the rules change
+	differ.ResetDiffs().WithTrimSpace(`\.Name$`).WithSorter(&stringSorter{})
+	differ.Compare(a, b)
+	suite.Len(differ.Diffs(), 1)
+	_, ok := differ.FindDiff("T.Attrs[k]")
+	suite.True(ok)
+
+	counter := &countingComparator{}
+	config := NewDiffer().WithComparator(counter).Config()
+	for i := 0; i < 3; i++ {
+		result, err := config.Compare(a, b)
+		suite.NoError(err)
+		suite.Len(result.Diffs(), 4)
+	}
+	// the plans of map values are compiled for each value, and only the others are cached.
+	suite.Equal(1, counter.matches["T.Attrs"])
+	suite.Equal(3, counter.matches["T.Attrs[k]"])
+	suite.Equal(3, counter.equals["T.Attrs[k]"])
+
+	// the indexes and the keys of the elements do not make the cache grow.
+	type M struct {
+		Items []Item
+		Attrs map[string]Item
+	}
+	newM := func(n int) M {
+		m := M{Attrs: make(map[string]Item, n)}
+		for i := 0; i < n; i++ {
+			m.Items = append(m.Items, Item{Name: strconv.Itoa(i)})
+			m.Attrs[strconv.Itoa(i)] = Item{Name: strconv.Itoa(i)}
+		}
+		return m
+	}
+	for _, differ := range []*Differ{NewDiffer(), NewDiffer().WithTrimSpace(`\.Name$`)} {
+		config := differ.Config()
+		result, err := config.Compare(newM(10), newM(10))
+		suite.NoError(err)
+		suite.Empty(result.Diffs())
+		cached := config.rules.plans.size
+		result, err = config.Compare(newM(100), newM(100))
+		suite.NoError(err)
+		suite.Empty(result.Diffs())
+		suite.Equal(cached, config.rules.plans.size)
+	}
+
+	// the rules telling the indexes apart are matched for each element.
+	config = NewDiffer().Ignore(`^M\.Items\[1\]`, `\.Attrs\[2\]`).Config()
+	for i := 0; i < 2; i++ {
+		m := newM(3)
+		m.Items[1].Name, m.Items[2].Name = "x", "y"
+		m.Attrs["2"], m.Attrs["1"] = Item{Name: "x"}, Item{Name: "y"}
+		result, err := config.Compare(newM(3), m)
+		suite.NoError(err)
+		names := make([]string, 0, 2)
+		for _, df := range result.Diffs() {
+			names = append(names, df.Name())
+		}
+		suite.Equal([]string{"M.Items[2].Name", "M.Attrs[1].Name"}, names)
+	}
+
+	// diffs under generic plans are still named by their own paths.
+	a.Items = append(a.Items, Item{Name: "b", Tags: []string{"z"}})
+	b.Items = append(b.Items, Item{Name: "b", Tags: []string{"w"}})
+	differ = NewDiffer().Compare(a, b)
+	_, ok = differ.FindDiff("T.Items[1].Tags[0]")
+	suite.True(ok)
+}
+
+// countingComparator compares the map values as case-sensitive strings, and counts the calls.
+type countingComparator struct {
+	matches map[string]int
+	equals  map[string]int
+}
+
+func (c *countingComparator) Match(fieldPath string) bool {
+	if c.matches == nil {
+		c.matches, c.equals = make(map[string]int), make(map[string]int)
+	}
+	c.matches[fieldPath]++
+	return strings.HasPrefix(fieldPath, "T.Attrs[")
+}
+
+func (c *countingComparator) Equals(a, b interface{}) (DiffType, interface{}, interface{}) {
+	c.equals["T.Attrs[k]"]++
+	if a.(string) != b.(string) {
+		return ElemDiff, a, b
+	}
+	return NoDiff, nil, nil
+}
+
+func (suite *DiffTestSuite) TestPrune() {
+	type Inner struct {
+		X int
+		Y int
+	}
+	type T struct {
+		A     Inner
+		Cache struct{ blob []byte }
+		Fn    func()
+		Name  string
+	}
+	a := T{A: Inner{X: 1, Y: 1}, Fn: func() {}, Name: "a"}
+	b := T{A: Inner{X: 2, Y: 1}, Fn: func() {}, Name: "b"}
+	a.Cache.blob, b.Cache.blob = []byte("a"), []byte("b")
+
+	differ := NewDiffer().Ignore(`^T\.A$`, `^T\.Cache$`, `^T\.Fn$`)
+	suite.NoError(differ.CompareE(a, b))
+	suite.Len(differ.Diffs(), 1)
+	_, ok := differ.FindDiff("T.Name")
+	suite.True(ok)
+
+	differ = NewDiffer().Ignore(`^T\.A$`, `^T\.Cache$`, `^T\.Fn$`).WithPostFilter()
+	var ufErr *UnexportedFieldError
+	suite.True(errors.As(differ.CompareE(a, b), &ufErr))
+	suite.Equal("T.Cache.blob[0]", ufErr.Path)
+
+	differ = NewDiffer().IgnorePath(func(p Path) bool {
+		return p.Last().Name() == "Cache" || p.Last().Name() == "Fn"
+	})
+	suite.NoError(differ.CompareE(a, b))
+	suite.Len(differ.Diffs(), 2)
+
+	differ = NewDiffer().Includes(`^T\.A$`)
+	suite.NoError(differ.CompareE(a, b))
+	suite.Len(differ.Diffs(), 1)
+	_, ok = differ.FindDiff("T.A.X")
+	suite.True(ok)
+
+	differ = NewDiffer().Includes(`^T\.A$`).WithPostFilter()
+	suite.Error(differ.CompareE(a, b))
+	differ = NewDiffer().Includes(`^T\.A$`).WithPostFilter().IgnoreUnexported()
+	suite.NoError(differ.CompareE(T{A: a.A}, T{A: b.A}))
+	suite.Empty(differ.Diffs())
+
+	// unanchored include rules can not prune the traversal
+	differ = NewDiffer().Includes(`\.X$`)
+	suite.Error(differ.CompareE(a, b))
+	suite.NoError(differ.ResetDiffs().CompareE(T{A: a.A}, T{A: b.A}))
+	suite.Len(differ.Diffs(), 1)
+
+	differ = NewDiffer().IncludesPath(func(p Path) bool {
+		return p.Last().Name() == "A"
+	})
+	suite.NoError(differ.CompareE(T{A: a.A, Name: "a"}, T{A: b.A, Name: "b"}))
+	suite.Len(differ.Diffs(), 1)
+	_, ok = differ.FindDiff("T.A.X")
+	suite.True(ok)
+}
+
+type taggedLine struct {
+	SKU   string  `sdiffer:"key"`
+	Qty   int     `sdiffer:"name=quantity"`
+	Price float64 `sdiffer:"tolerance=0.01"`
+}
+
+type taggedOrder struct {
+	ID        string
+	Remark    string         `sdiffer:"trimspace"`
+	Code      string         `sdiffer:"trim=#"`
+	Tags      []string       `sdiffer:"unordered,trimspace"`
+	Lines     []*taggedLine  `sdiffer:"name=lines"`
+	Cache     map[string]int `sdiffer:"-"`
+	UpdatedAt int            `sdiffer:"-"`
+	Note      string
+}
+
+func (suite *DiffTestSuite) TestStructTag() {
+	a := taggedOrder{
+		ID:     "1",
+		Remark: "remark",
+		Code:   "##x##",
+		Tags:   []string{"a", "b "},
+		Lines: []*taggedLine{
+			{SKU: "s1", Qty: 1, Price: 1.001},
+			{SKU: "s2", Qty: 2, Price: 2},
+		},
+		Cache:     map[string]int{"k": 1},
+		UpdatedAt: 1,
+		Note:      " n ",
+	}
+	b := taggedOrder{
+		ID:     "1",
+		Remark: " remark ",
+		Code:   "x#",
+		Tags:   []string{"b", " a"},
+		Lines: []*taggedLine{
+			{SKU: "s3", Qty: 3},
+			{SKU: "s2", Qty: 20, Price: 2.005},
+			{SKU: "s1", Qty: 1, Price: 1.1},
+		},
+		Cache:     map[string]int{"k": 2},
+		UpdatedAt: 2,
+		Note:      "n",
+	}
+	differ := NewDiffer()
+	suite.NoError(differ.CompareE(a, b))
+	names := make([]string, 0)
+	for _, df := range differ.Diffs() {
+		names = append(names, df.Name())
+	}
+	suite.ElementsMatch([]string{
+		"taggedOrder.lines[SKU=s1].Price",
+		"taggedOrder.lines[SKU=s2].quantity",
+		"taggedOrder.lines[SKU=s3]",
+		"taggedOrder.Note",
+	}, names)
+	df, _ := differ.FindDiff("taggedOrder.lines[SKU=s2].quantity")
+	suite.Equal("taggedOrder.lines.quantity", df.Tag())
+	suite.Equal("Qty", df.Path().Last().Name())
+
+	// tag rules combine with the rules of Differ
+	differ = NewDiffer().WithTrimSpace(`\.Note$`).Ignore(`\.Price$`)
+	suite.NoError(differ.CompareE(a, b))
+	suite.Len(differ.Diffs(), 2)
+	differ = NewDiffer().WithSliceKey(`\.lines$`, "qty", func(elem interface{}) interface{} {
+		return elem.(*taggedLine).Qty
+	}).Includes(`^taggedOrder\.lines`)
+	suite.NoError(differ.CompareE(a, b))
+	_, ok := differ.FindDiff("taggedOrder.lines[qty=1].Price")
+	suite.True(ok)
+
+	type badTag struct {
+		F float64 `sdiffer:"tolerance=x"`
+	}
+	var irErr *InvalidRuleError
+	suite.True(errors.As(NewDiffer().CompareE(badTag{}, badTag{}), &irErr))
+	suite.Equal("tag", irErr.Rule)
+	type unknownTag struct {
+		F int `sdiffer:"fuzzy"`
+	}
+	suite.Error(NewDiffer().CompareE(unknownTag{}, unknownTag{}))
+}
+
+// money is a value object whose Equal method ignores the case of the currency.
+type money struct {
+	Amount   int
+	Currency string
+}
+
+func (m money) Equal(other money) bool {
+	return m.Amount == other.Amount && strings.EqualFold(m.Currency, other.Currency)
+}
+
+// version implements Equaler by its major number.
+type version struct {
+	Major, Minor int
+}
+
+func (v *version) Equal(other interface{}) bool {
+	o, ok := other.(*version)
+	return ok && v.Major == o.Major
+}
+
+func (suite *DiffTestSuite) TestEqualMethods() {
+	type T struct {
+		At      time.Time
+		Price   money
+		Version *version
+		Any     interface{}
+	}
+	at := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	a := T{At: at, Price: money{1, "usd"}, Version: &version{1, 0}, Any: at}
+	b := T{At: at.In(time.FixedZone("UTC+8", 8*3600)), Price: money{1, "USD"}, Version: &version{1, 2}, Any: at.Local()}
+
+	differ := NewDiffer().WithEqualMethods()
+	suite.NoError(differ.CompareE(a, b))
+	suite.Empty(differ.Diffs())
+
+	suite.Error(NewDiffer().AllowUnexported().CompareE(a, b), "Any holds an unexpected type")
+	differ = NewDiffer().AllowUnexported().Ignore(`\.Any$`)
+	suite.NoError(differ.CompareE(a, b))
+	suite.NotEmpty(differ.FindDiffFuzzily(`^T\.At\.`))
+
+	b = T{At: at.Add(time.Second), Price: money{2, "usd"}, Version: &version{2, 0}, Any: at.Add(time.Second)}
+	differ = NewDiffer().WithEqualMethods()
+	suite.NoError(differ.CompareE(a, b))
+	names := make([]string, 0)
+	for _, df := range differ.Diffs() {
+		names = append(names, df.Name())
+	}
+	suite.Equal([]string{"T.At", "T.Price", "T.Version", "T.Any"}, names)
+	df, _ := differ.FindDiff("T.At")
+	suite.Equal(at, df.A())
+
+	b.Version = nil
+	differ = NewDiffer().WithEqualMethods().Includes(`^T\.Version$`)
+	suite.NoError(differ.CompareE(a, b))
+	df, _ = differ.FindDiff("T.Version")
+	suite.Equal(NilMismatch, df.Kind())
+
+	differ = NewDiffer().WithEqualMethods().WithComparator(&moneyComparator{})
+	suite.NoError(differ.CompareE(money{1, "usd"}, money{1, "USD"}))
+	suite.Len(differ.Diffs(), 1)
+}
+
+// moneyComparator compares money strictly.
+type moneyComparator struct{}
+
+func (c *moneyComparator) Match(fieldPath string) bool {
+	return fieldPath == "money"
+}
+
+func (c *moneyComparator) Equals(a, b interface{}) (DiffType, interface{}, interface{}) {
+	if a != b {
+		return ElemDiff, a, b
+	}
+	return NoDiff, nil, nil
+}
+
+// secondSorter sorts the slices of time.Time wherever they are.
+type secondSorter struct{}
+
+func (s *secondSorter) Match(string) bool {
+	return false
+}
+
+func (s *secondSorter) MatchType(_ string, t reflect.Type) bool {
+	return t == reflect.TypeOf([]time.Time{})
+}
+
+func (s *secondSorter) Less(a, b interface{}) bool {
+	return a.(time.Time).Before(b.(time.Time))
+}
+
+// stringerComparator compares the fmt.Stringer values by their strings.
+type stringerComparator struct{}
+
+func (c *stringerComparator) Match(string) bool {
+	return false
+}
+
+func (c *stringerComparator) MatchType(fieldPath string, t reflect.Type) bool {
+	return strings.HasPrefix(fieldPath, "T.") && t.Implements(reflect.TypeOf((*fmt.Stringer)(nil)).Elem())
+}
+
+func (c *stringerComparator) Equals(a, b interface{}) (DiffType, interface{}, interface{}) {
+	sa, sb := a.(fmt.Stringer).String(), b.(fmt.Stringer).String()
+	if sa != sb {
+		return ElemDiff, sa, sb
+	}
+	return NoDiff, nil, nil
+}
+
+func (suite *DiffTestSuite) TestTypeRules() {
+	type Inner struct {
+		mu    sync.Mutex
+		At    time.Time
+		Times []time.Time
+	}
+	type T struct {
+		Inner   Inner
+		Inners  []*Inner
+		Any     interface{}
+		Timeout time.Duration
+	}
+	at := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	a := &T{
+		Inner:   Inner{At: at, Times: []time.Time{at, at.Add(time.Hour)}},
+		Inners:  []*Inner{{At: at}},
+		Any:     at,
+		Timeout: time.Second,
+	}
+	b := &T{
+		Inner:   Inner{At: at.Add(time.Millisecond), Times: []time.Time{at.Add(time.Hour), at}},
+		Inners:  []*Inner{{At: at.Add(time.Millisecond)}},
+		Any:     at.Add(time.Millisecond),
+		Timeout: time.Second,
+	}
+	b.Inners[0].mu.Lock()
+
+	differ := NewDiffer().
+		IgnoreTypes(reflect.TypeOf((*sync.Mutex)(nil)).Elem()).
+		WithTypeComparator(reflect.TypeOf(time.Time{}), func(a, b interface{}) bool {
+			return a.(time.Time).Unix() == b.(time.Time).Unix()
+		}).
+		WithSorter(&secondSorter{})
+	suite.NoError(differ.CompareE(a, b))
+	suite.Empty(differ.Diffs())
+
+	b.Any = at.Add(time.Minute)
+	suite.NoError(differ.ResetDiffs().CompareE(a, b))
+	suite.Len(differ.Diffs(), 1)
+	df, ok := differ.FindDiff("T.Any")
+	suite.True(ok)
+	suite.Equal(Changed, df.Kind())
+
+	differ = NewDiffer().IgnoreTypes(reflect.TypeOf(Inner{}), reflect.TypeOf(time.Time{}))
+	suite.NoError(differ.CompareE(a, b))
+	suite.Empty(differ.Diffs())
+
+	// added or removed values of the ignored types and the lengths of their containers are ignored too.
+	type Times struct {
+		M map[string]time.Time
+		S []time.Time
+		I []interface{}
+	}
+	differ = NewDiffer().IgnoreTypes(reflect.TypeOf(time.Time{})).WithEditScript(`^Times\.I$`)
+	suite.NoError(differ.CompareE(&Times{M: map[string]time.Time{}, S: []time.Time{}, I: []interface{}{}},
+		&Times{M: map[string]time.Time{"k": at}, S: []time.Time{at}, I: []interface{}{at}}))
+	suite.Empty(differ.Diffs())
+
+	b.Timeout = time.Minute
+	differ = NewDiffer().WithComparator(&stringerComparator{}).IgnoreTypes(reflect.TypeOf(Inner{}), reflect.TypeOf(time.Time{}))
+	suite.NoError(differ.CompareE(a, b))
+	df, ok = differ.FindDiff("T.Timeout.$[customized]")
+	suite.True(ok)
+	suite.Equal("1m0s", df.Vb())
+}
+
+func (suite *DiffTestSuite) TestTransform() {
+	type T struct {
+		Email   string
+		Payload string
+		Tags    []string
+	}
+	a := &T{Email: "Foo@Example.com", Payload: `{"name":"foo","n":1}`, Tags: []string{"b", "a"}}
+	b := &T{Email: "foo@example.com", Payload: `{"n":1,"name":"foo"}`, Tags: []string{"a", "b"}}
+	parseJSON := func(v interface{}) interface{} {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(v.(string)), &m); err != nil {
+			return v
+		}
+		return m
+	}
+	differ := NewDiffer().
+		WithTransform(`^T\.Email$`, func(v interface{}) interface{} {
+			return strings.ToLower(v.(string))
+		}).
+		WithTransform(`^T\.Payload$`, parseJSON).
+		WithTransform(`^T\.Tags$`, func(v interface{}) interface{} {
+			tags := append([]string(nil), v.([]string)...)
+			sort.Strings(tags)
+			return tags
+		})
+	suite.NoError(differ.CompareE(a, b))
+	suite.Empty(differ.Diffs())
+
+	b.Payload = `{"n":2,"name":"foo"}`
+	b.Email = "bar@example.com"
+	suite.NoError(differ.ResetDiffs().CompareE(a, b))
+	suite.Len(differ.Diffs(), 2)
+	df, ok := differ.FindDiff("T.Payload[n]")
+	suite.True(ok)
+	suite.Equal(Changed, df.Kind())
+	oa, ob, ok := df.Original()
+	suite.True(ok)
+	suite.Equal(a.Payload, oa)
+	suite.Equal(b.Payload, ob)
+	suite.Equal(`1 (original: {"name":"foo","n":1})`, df.Va())
+	suite.Equal(`2 (original: {"n":2,"name":"foo"})`, df.Vb())
+
+	// a transformed value is replaced as a whole by the original value of B.
+	patch, err := differ.MergePatch()
+	suite.NoError(err)
+	suite.JSONEq(`{"Email": "bar@example.com", "Payload": "{\"n\":2,\"name\":\"foo\"}"}`, string(patch))
+	patch, err = differ.JSONPatch()
+	suite.NoError(err)
+	suite.JSONEq(`[
+		{"op": "replace", "path": "/Email", "value": "bar@example.com"},
+		{"op": "replace", "path": "/Payload", "value": "{\"n\":2,\"name\":\"foo\"}"}
+	]`, string(patch))
+	df, ok = differ.FindDiff("T.Email")
+	suite.True(ok)
+	suite.Equal("foo@example.com (original: Foo@Example.com)", df.Va())
+	suite.Equal("bar@example.com", df.Vb())
+
+	_, _, ok = (&Diff{}).Original()
+	suite.False(ok)
+
+	suite.Error(NewDiffer().WithTransformE(`(`, parseJSON))
+	suite.Panics(func() {
+		NewDiffer().WithTransform(`(`, parseJSON)
+	})
+}
+
+func (suite *DiffTestSuite) TestDiffPathsAreKept() {
+	a, b := benchPeople(3), benchPeople(3)
+	for i := range b {
+		b[i].Name += "!"
+		b[i].Loc.Province.Name += "!"
+	}
+	differ := NewDiffer().Compare(a, b)
+
+	suite.Len(differ.Diffs(), 6)
+	for _, df := range differ.Diffs() {
+		suite.Equal(df.Name(), df.Path().String())
+	}
+	df, ok := differ.FindDiff("$[2].Loc.Province.Name")
+	suite.True(ok)
+	suite.Equal(2, df.Path()[1].Index())
+}
+
+func (suite *DiffTestSuite) TestIndexBlind() {
+	for expr, blind := range map[string]bool{
+		`\.Name$`:            true,
+		`^T\.Items.*\.Name$`: true,
+		`[^.]+\.Name`:        true,
+		`(?i)^t\.items`:      true,
+		`^T\.Items\[0\]`:     false,
+		`Items\[\d+\]\.Name`: false,
+		`\.v\d`:              false,
+		`Items.Name`:         false,
+		`Items.{3}Name`:      false,
+		`[a-z0-5]+\.Name`:    false,
+	} {
+		suite.Equal(blind, indexBlind(regexp.MustCompile(expr)), expr)
+	}
+}
+
 func (suite *DiffTestSuite) TestChore() {
 	// ...
 }
+
+func benchPeople(n int) []Person {
+	people := make([]Person, n)
+	for i := range people {
+		people[i] = Person{
+			Name:   "person" + strconv.Itoa(i),
+			Age:    i,
+			Loc:    &Location{Name: "city", Province: &Location{Name: "province"}},
+			StrArr: []string{"a", "b", "c"},
+		}
+	}
+	return people
+}
+
+func BenchmarkCompareEqualStructSlice(b *testing.B) {
+	x, y := benchPeople(100), benchPeople(100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NewDiffer().Compare(x, y)
+	}
+}
+
+func BenchmarkCompareEqualStructSliceWithRules(b *testing.B) {
+	x, y := benchPeople(100), benchPeople(100)
+	differ := NewDiffer().Ignore(`\.Age$`).WithTrimSpace(`\.Name$`)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		differ.Compare(x, y)
+	}
+}
+
+func BenchmarkCompareEqualStructMap(b *testing.B) {
+	x, y := make(map[string]Person), make(map[string]Person)
+	for i, p := range benchPeople(100) {
+		x[strconv.Itoa(i)] = p
+	}
+	for i, p := range benchPeople(100) {
+		y[strconv.Itoa(i)] = p
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NewDiffer().Compare(x, y)
+	}
+}
@@ -0,0 +1,157 @@
+package sdiffer
+
+import (
+	"reflect"
+)
+
+// compareEditScript aligns the elements of slices a and b by their longest common subsequence,
+// the unaligned elements between two aligned ones are compared in pairs, and the rest of them
+// are reported as Removed or Added. It returns false without any diff if the edit distance
+// of the slices exceeds maxEditDistance.
+func (d *Differ) compareEditScript(a, b reflect.Value, path Path, depth int) bool {
+	n, m := a.Len(), b.Len()
+	equal := func(i, j int) bool {
+		ea, eb := a.Index(i), b.Index(j)
+		return d.isEqual(ea, eb, path.next(PathStep{kind: IndexStep, index: j}, ea, eb), depth)
+	}
+
+	// skip the common prefix and suffix, which is the most common case.
+	start := 0
+	for start < n && start < m && equal(start, start) {
+		start++
+	}
+	endA, endB := n, m
+	for endA > start && endB > start && equal(endA-1, endB-1) {
+		endA--
+		endB--
+	}
+
+	var dels, ins []int
+	flush := func() {
+		k := 0
+		for ; k < len(dels) && k < len(ins); k++ {
+			ea, eb := a.Index(dels[k]), b.Index(ins[k])
+			d.doCompare(ea, eb, path.next(PathStep{kind: IndexStep, index: ins[k]}, ea, eb), depth)
+		}
+		for _, i := range dels[k:] {
+			ea := a.Index(i)
+			d.setMissingDiff(path.next(PathStep{kind: IndexStep, index: i}, ea, reflect.Value{}), ea, reflect.Value{})
+		}
+		for _, j := range ins[k:] {
+			eb := b.Index(j)
+			d.setMissingDiff(path.next(PathStep{kind: IndexStep, index: j}, reflect.Value{}, eb), reflect.Value{}, eb)
+		}
+		dels, ins = dels[:0], ins[:0]
+	}
+
+	ops, ok := myersEditScript(endA-start, endB-start, func(i, j int) bool {
+		return equal(start+i, start+j)
+	})
+	if !ok {
+		return false
+	}
+	for _, op := range ops {
+		switch op.kind {
+		case editKeep:
+			flush()
+		case editDelete:
+			dels = append(dels, start+op.i)
+		case editInsert:
+			ins = append(ins, start+op.j)
+		}
+	}
+	flush()
+	return true
+}
+
+type editKind int
+
+const (
+	editKeep editKind = iota
+	editDelete
+	editInsert
+)
+
+type editOp struct {
+	kind editKind
+	i, j int
+}
+
+// maxEditDistance limits the edit distance searched by myersEditScript, since the time and
+// memory it takes grow with the distance.
+const maxEditDistance = 1 << 10
+
+// myersEditScript returns the shortest edit script which turns a sequence of length n into
+// a sequence of length m, equal(i, j) reports whether the i-th and the j-th elements are equal.
+// It takes O((n+m)·D) time and O(D²) memory, where D is the edit distance, see
+// "An O(ND) Difference Algorithm and Its Variations" by Eugene W. Myers.
+// ok is false if D exceeds maxEditDistance.
+func myersEditScript(n, m int, equal func(i, j int) bool) (ops []editOp, ok bool) {
+	limit := minInt(n+m, maxEditDistance)
+	// v[offset+k] is the furthest x reached on the diagonal k = x - y.
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	// trace[dist] is v[-dist..dist] after the round of dist edits.
+	var trace [][]int
+	for dist := 0; dist <= limit; dist++ {
+		for k := -dist; k <= dist; k += 2 {
+			var x int
+			if k == -dist || k != dist && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && equal(x, y) {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackEditScript(n, m, dist, trace), true
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-dist:offset+dist+1]...))
+	}
+	return nil, false
+}
+
+// backtrackEditScript rebuilds the edit script of dist edits found by myersEditScript.
+func backtrackEditScript(n, m, dist int, trace [][]int) []editOp {
+	ops := make([]editOp, 0, n+m)
+	x, y := n, m
+	for ; dist > 0; dist-- {
+		prev := trace[dist-1]
+		at := func(k int) int {
+			return prev[k+dist-1]
+		}
+		k := x - y
+		prevK := k - 1
+		if k == -dist || k != dist && at(k-1) < at(k+1) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, editOp{editKeep, x, y})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, editOp{editInsert, x, y})
+		} else {
+			x--
+			ops = append(ops, editOp{editDelete, x, y})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, editOp{editKeep, x, y})
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
@@ -0,0 +1,65 @@
+package sdiffer
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Equaler can be implemented by a type to tell Differ whether two of its values are equal,
+// see Differ.WithEqualMethods.
+type Equaler interface {
+	Equal(other interface{}) bool
+}
+
+var boolType = reflect.TypeOf(true)
+
+// equalMethodCache caches the indexes of the Equal methods by the types, -1 means no such method.
+var equalMethodCache sync.Map
+
+// equalMethod returns the index of the Equal method of t, which must be defined as
+// (T) Equal(U) bool, where T is assignable to U, such as time.Time.Equal or Equaler.Equal.
+func equalMethod(t reflect.Type) (int, bool) {
+	if index, ok := equalMethodCache.Load(t); ok {
+		return index.(int), index.(int) >= 0
+	}
+	index := -1
+	if t.Kind() != reflect.Interface {
+		if m, ok := t.MethodByName("Equal"); ok {
+			mt := m.Type
+			if mt.NumIn() == 2 && mt.NumOut() == 1 && mt.Out(0) == boolType && t.AssignableTo(mt.In(1)) {
+				index = m.Index
+			}
+		}
+	}
+	equalMethodCache.Store(t, index)
+	return index, index >= 0
+}
+
+// compareByEqualMethod compares a and b with their Equal method, ok is false if they have no
+// such method or the method can not be called, for example on nil pointers.
+func (d *Differ) compareByEqualMethod(a, b reflect.Value, path Path) (ok bool) {
+	if !d.equalMethods || !a.CanInterface() || !b.CanInterface() {
+		return false
+	}
+	if a.Kind() == reflect.Interface {
+		if a.IsNil() || b.IsNil() || a.Elem().Type() != b.Elem().Type() {
+			return false
+		}
+		ea, eb := a.Elem(), b.Elem()
+		return d.compareByEqualMethod(ea, eb, path.next(PathStep{kind: TypeAssertStep}, ea, eb))
+	}
+	index, ok := equalMethod(a.Type())
+	if !ok {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		if a.IsNil() || b.IsNil() {
+			return false
+		}
+	}
+	if !a.Method(index).Call([]reflect.Value{b})[0].Bool() {
+		d.setDiff(path, a, b)
+	}
+	return true
+}
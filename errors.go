@@ -0,0 +1,123 @@
+package sdiffer
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// TypeMismatchError is returned when two values with different types are compared.
+type TypeMismatchError struct {
+	Path  string
+	TypeA reflect.Type
+	TypeB reflect.Type
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("type mismatch at %q: A: %v, B: %v", e.Path, e.TypeA, e.TypeB)
+}
+
+// DepthExceededError is returned when the comparison goes deeper than the max depth of Differ.
+type DepthExceededError struct {
+	Path     string
+	MaxDepth int
+}
+
+func (e *DepthExceededError) Error() string {
+	return fmt.Sprintf("depth over limit at %q: max depth is %d", e.Path, e.MaxDepth)
+}
+
+// InvalidValueError is returned when one of the compared values is invalid, such as an untyped nil.
+type InvalidValueError struct {
+	Path string
+}
+
+func (e *InvalidValueError) Error() string {
+	return fmt.Sprintf("value invalid at %q", e.Path)
+}
+
+// UnexpectedTypeError is returned when an interface holds a type which Differ does not know how to compare.
+type UnexpectedTypeError struct {
+	Path string
+	Type reflect.Type
+}
+
+func (e *UnexpectedTypeError) Error() string {
+	return fmt.Sprintf("unexpected interface with type %v at %q", e.Type, e.Path)
+}
+
+// UnexportedFieldError is returned when Differ has to read an unexported field,
+// see Differ.AllowUnexported and Differ.IgnoreUnexported.
+type UnexportedFieldError struct {
+	Path string
+}
+
+func (e *UnexportedFieldError) Error() string {
+	return fmt.Sprintf("cannot read unexported field at %q", e.Path)
+}
+
+// InvalidRuleError is returned when a rule passed to Differ cannot be compiled.
+type InvalidRuleError struct {
+	Rule string
+	Expr string
+	Err  error
+}
+
+func (e *InvalidRuleError) Error() string {
+	return fmt.Sprintf("invalid %s rule %q: %v", e.Rule, e.Expr, e.Err)
+}
+
+func (e *InvalidRuleError) Unwrap() error {
+	return e.Err
+}
+
+// ComparatorError is returned when a customized Comparator violates its contract,
+// for example by returning an unexpected DiffType.
+type ComparatorError struct {
+	Path     string
+	DiffType DiffType
+}
+
+func (e *ComparatorError) Error() string {
+	return fmt.Sprintf("customized comparator returned an unexpected DiffType %d at %q", e.DiffType, e.Path)
+}
+
+// SliceKeyError is returned when the keys of the elements of a slice cannot be used to pair them,
+// see Differ.WithSliceKey.
+type SliceKeyError struct {
+	Path   string
+	Key    interface{}
+	Reason string
+}
+
+func (e *SliceKeyError) Error() string {
+	return fmt.Sprintf("invalid slice key %v at %q: %s", e.Key, e.Path, e.Reason)
+}
+
+// ApplyError is returned when diffs can not be applied onto a target value.
+type ApplyError struct {
+	Path   string
+	Reason string
+}
+
+func (e *ApplyError) Error() string {
+	return fmt.Sprintf("cannot apply diff at %q: %s", e.Path, e.Reason)
+}
+
+// throw aborts the current comparison with err, which will be recovered by CompareE.
+func throw(err error) {
+	panic(err)
+}
+
+// catch recovers the errors thrown by throw and stores them into err,
+// any other panic will be re-panicked.
+func catch(err *error) {
+	if r := recover(); r != nil {
+		switch r.(type) {
+		case *TypeMismatchError, *DepthExceededError, *InvalidValueError,
+			*UnexpectedTypeError, *UnexportedFieldError, *InvalidRuleError, *ComparatorError, *SliceKeyError, *ApplyError:
+			*err = r.(error)
+		default:
+			panic(r)
+		}
+	}
+}
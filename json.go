@@ -0,0 +1,59 @@
+package sdiffer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// CompareJSON compares two JSON documents, see CompareJSONReader.
+func (d *Differ) CompareJSON(a, b []byte) error {
+	return d.CompareJSONReader(bytes.NewReader(a), bytes.NewReader(b))
+}
+
+// CompareJSONReader decodes a JSON document from each reader and compares them.
+//
+// Numbers are decoded as json.Number to avoid losing precision and compared numerically,
+// the documents can be objects, arrays, scalars or null, and values of different JSON types
+// are reported as Changed diffs. Diffs are named by JSON Pointers, such as /items/0/name,
+// so are the paths which the rules of Differ match against.
+func (d *Differ) CompareJSONReader(a, b io.Reader) (err error) {
+	docA, err := decodeJSON(a)
+	if err != nil {
+		return err
+	}
+	docB, err := decodeJSON(b)
+	if err != nil {
+		return err
+	}
+	return d.compareJSONValues(docA, docB)
+}
+
+// compareJSONValues compares two decoded JSON documents.
+func (d *Differ) compareJSONValues(docA, docB interface{}) (err error) {
+	defer catch(&err)
+	d.jsonPaths = true
+	defer func() {
+		d.jsonPaths = false
+	}()
+	d.visitedA = make(map[visit]visitRecord)
+	d.visitedB = make(map[visit]visitRecord)
+	va, vb := reflect.ValueOf(&docA).Elem(), reflect.ValueOf(&docB).Elem()
+	d.doCompare(va, vb, newStack().next(PathStep{kind: RootStep}, va, vb), 0)
+	return nil
+}
+
+// decodeJSON decodes exactly one JSON document from r.
+func decodeJSON(r io.Reader) (doc interface{}, err error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err = dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if _, err = dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid character after top-level value at offset %d", dec.InputOffset())
+	}
+	return doc, nil
+}
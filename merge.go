@@ -0,0 +1,156 @@
+package sdiffer
+
+import (
+	"reflect"
+)
+
+// Conflict is a field changed by both ours and theirs in different ways.
+type Conflict struct {
+	Path   string
+	Base   interface{}
+	Ours   interface{}
+	Theirs interface{}
+}
+
+// Merge does a three-way merge, the changes from base to ours and from base to theirs are
+// both applied onto a deep copy of base, which is returned as merged. The values taken from
+// ours and theirs are deep copied as well, so merged shares no memory with the inputs.
+//
+// Changes of the two sides conflict with each other when one of them changes a field which
+// contains the field changed by the other one, or when one of them adds or removes elements
+// of a slice which contains the field changed by the other one. Conflicting changes are not
+// applied, and they are returned as conflicts keyed by the outermost conflicting field.
+// Identical changes of the two sides are applied only once.
+//
+// A slice compared by key with WithSliceKey, or sorted by a Sorter, is replaced as a whole by
+// the patches, so any changes of its elements by both sides conflict, even of different elements.
+func (d *Differ) Merge(base, ours, theirs interface{}) (merged interface{}, conflicts []*Conflict, err error) {
+	if base == nil {
+		return nil, nil, &InvalidValueError{Path: initTypeName}
+	}
+	diffsOurs, err := d.collectDiffs(base, ours)
+	if err != nil {
+		return nil, nil, err
+	}
+	diffsTheirs, err := d.collectDiffs(base, theirs)
+	if err != nil {
+		return nil, nil, err
+	}
+	opsOurs, opsTheirs := buildPatchOps(diffsOurs), buildPatchOps(diffsTheirs)
+
+	conflicted := make(map[string]bool)
+	duplicated := make([]bool, len(opsTheirs))
+	for _, o := range opsOurs {
+		for j, t := range opsTheirs {
+			if isSamePatchOp(o, t) {
+				duplicated[j] = true
+				continue
+			}
+			c, ok := conflictPath(o, t)
+			if !ok || conflicted[c.String()] {
+				continue
+			}
+			conflicted[c.String()] = true
+			conflicts = append(conflicts, newConflict(c, o, t))
+		}
+	}
+
+	ops := make([]patchOp, 0, len(opsOurs)+len(opsTheirs))
+	for _, o := range opsOurs {
+		if !isUnderConflicts(o.path, conflicts) {
+			ops = append(ops, o)
+		}
+	}
+	for j, t := range opsTheirs {
+		if !duplicated[j] && !isUnderConflicts(t.path, conflicts) {
+			ops = append(ops, t)
+		}
+	}
+
+	target := reflect.New(reflect.TypeOf(base))
+	target.Elem().Set(deepCopy(reflect.ValueOf(base), make(map[visit]reflect.Value)))
+	if err = applyOps(target.Interface(), ops); err != nil {
+		return nil, nil, err
+	}
+	return target.Elem().Interface(), conflicts, nil
+}
+
+// collectDiffs compares a and b with the rules of d, without touching the diffs of d.
+func (d *Differ) collectDiffs(a, b interface{}) ([]*Diff, error) {
+	diffs, diffList := d.diffs, d.diffList
+	d.diffs, d.diffList = make(map[string]*Diff), nil
+	defer func() {
+		d.diffs, d.diffList = diffs, diffList
+	}()
+	err := d.CompareE(a, b)
+	return d.diffList, err
+}
+
+func isSamePatchOp(o, t patchOp) bool {
+	return o.op == t.op && o.path.Equal(t.path) && reflect.DeepEqual(o.value, t.value)
+}
+
+// patchScope returns the path which op affects, adding or removing an element of a slice
+// affects the whole slice, since the indexes of the following elements are changed.
+func patchScope(op patchOp) Path {
+	if op.op != opReplace && op.path.Last().kind == IndexStep {
+		return op.path[:len(op.path)-1]
+	}
+	return op.path
+}
+
+// conflictPath checks if o and t conflict with each other, and returns the outermost path of the conflict.
+func conflictPath(o, t patchOp) (Path, bool) {
+	so, st := patchScope(o), patchScope(t)
+	switch {
+	case isPathPrefix(so, t.path) && (!isPathPrefix(st, o.path) || len(so) <= len(st)):
+		return so, true
+	case isPathPrefix(st, o.path):
+		return st, true
+	}
+	return nil, false
+}
+
+func isUnderConflicts(p Path, conflicts []*Conflict) bool {
+	for _, c := range conflicts {
+		s := p.String()
+		if s == c.Path || (len(s) > len(c.Path) && s[:len(c.Path)] == c.Path &&
+			(s[len(c.Path)] == '.' || s[len(c.Path)] == '[')) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPathPrefix checks if p is a prefix of q.
+func isPathPrefix(p, q Path) bool {
+	if len(p) > len(q) {
+		return false
+	}
+	for i := range p {
+		if p[i].kind != q[i].kind || p[i].String() != q[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
+func newConflict(p Path, o, t patchOp) *Conflict {
+	step := len(p) - 1
+	va, vo := o.path[step].Values()
+	_, vt := t.path[step].Values()
+	return &Conflict{
+		Path:   p.String(),
+		Base:   optionalInterface(va),
+		Ours:   optionalInterface(vo),
+		Theirs: optionalInterface(vt),
+	}
+}
+
+// optionalInterface is like valueInterface but returns nil for an invalid value.
+func optionalInterface(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	return valueInterface(v)
+}
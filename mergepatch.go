@@ -0,0 +1,239 @@
+package sdiffer
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// MergePatch converts the diffs into a JSON Merge Patch document which turns A into B, see RFC 7386.
+//
+// Like PatchOps, struct fields are named by their json tags, and diffs of fields ignored by
+// encoding/json are dropped. Since a merge patch can not address array elements, slices
+// containing diffs are replaced as a whole, so are the values compared after being transformed,
+// and removed map keys are set to null. Diffs of pointer cycles are dropped, since they can not
+// be represented in JSON.
+func (d *Differ) MergePatch() ([]byte, error) {
+	return d.result().MergePatch()
+}
+
+// MergePatch converts the diffs into a JSON Merge Patch document, see Differ.MergePatch.
+func (r *Result) MergePatch() ([]byte, error) {
+	var patch interface{} = map[string]interface{}{}
+	for _, df := range r.diffList {
+		path, value, ok := mergePatchEntry(df)
+		if !ok {
+			continue
+		}
+		if _, ok = path.jsonPointer(); !ok {
+			continue
+		}
+		tokens := make([]string, 0, len(path))
+		for _, s := range path {
+			if token, ok := s.jsonToken(); ok {
+				tokens = append(tokens, token)
+			}
+		}
+		if len(tokens) == 0 {
+			patch = value
+			break
+		}
+		setMergePatchValue(patch, tokens, value)
+	}
+	return json.Marshal(patch)
+}
+
+// mergePatchEntry returns where and what df changes in a merge patch.
+func mergePatchEntry(df *Diff) (path Path, value interface{}, ok bool) {
+	if df.kind == CycleMismatch {
+		return nil, nil, false
+	}
+	for k := 1; k < len(df.path); k++ {
+		if s := df.path[k]; s.kind == IndexStep || s.kind == SliceKeyStep || s.kind == TransformStep {
+			_, vb := df.path[k-1].Values()
+			return df.path[:k], valueInterface(vb), true
+		}
+	}
+	switch df.kind {
+	case Removed:
+		return df.path, nil, true
+	case LengthMismatch:
+		if df.typ != nil && df.typ.Kind() == reflect.Map {
+			return nil, nil, false
+		}
+	}
+	return df.path, df.b, true
+}
+
+// setMergePatchValue sets value into the nested objects of patch, a value which has been set
+// on one of the parents of tokens wins.
+func setMergePatchValue(patch interface{}, tokens []string, value interface{}) {
+	for i, token := range tokens {
+		obj, ok := patch.(map[string]interface{})
+		if !ok {
+			return
+		}
+		if i == len(tokens)-1 {
+			obj[token] = value
+			return
+		}
+		child, ok := obj[token]
+		if !ok {
+			child = map[string]interface{}{}
+			obj[token] = child
+		}
+		patch = child
+	}
+}
+
+// ApplyMergePatch applies a JSON Merge Patch document onto target, which must be a non-nil pointer.
+//
+// Objects in the patch are merged into structs, maps and pointers to them, members set to null
+// reset struct fields to their zero values and delete map keys, other values replace the
+// original ones as json.Unmarshal does.
+func ApplyMergePatch(target interface{}, patch []byte) (err error) {
+	defer catch(&err)
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &ApplyError{Path: "", Reason: "target must be a non-nil pointer"}
+	}
+	mergeInto(rv.Elem(), json.RawMessage(patch), "")
+	return nil
+}
+
+func mergeInto(v reflect.Value, patch json.RawMessage, pointer string) {
+	fail := func(reason string) {
+		throw(&ApplyError{Path: pointer, Reason: reason})
+	}
+	trimmed := bytes.TrimSpace(patch)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		if bytes.Equal(trimmed, []byte("null")) {
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		replaced := reflect.New(v.Type())
+		if err := json.Unmarshal(patch, replaced.Interface()); err != nil {
+			fail(err.Error())
+		}
+		v.Set(replaced.Elem())
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		mergeInto(v.Elem(), patch, pointer)
+	case reflect.Interface:
+		var p interface{}
+		if err := json.Unmarshal(patch, &p); err != nil {
+			fail(err.Error())
+		}
+		var current interface{}
+		if !v.IsNil() {
+			current = v.Elem().Interface()
+		}
+		v.Set(reflect.ValueOf(mergePatchValue(current, p)))
+	case reflect.Map:
+		members := decodeMembers(patch, fail)
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for name, member := range members {
+			key := reflect.New(v.Type().Key()).Elem()
+			if key.Kind() == reflect.String {
+				key.SetString(name)
+			} else if err := json.Unmarshal([]byte(name), key.Addr().Interface()); err != nil {
+				fail(err.Error())
+			}
+			if bytes.Equal(bytes.TrimSpace(member), []byte("null")) {
+				v.SetMapIndex(key, reflect.Value{})
+				continue
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if old := v.MapIndex(key); old.IsValid() {
+				elem.Set(old)
+			}
+			mergeInto(elem, member, concat(pointer, "/", pointerEscaper.Replace(name)))
+			v.SetMapIndex(key, elem)
+		}
+	case reflect.Struct:
+		for name, member := range decodeMembers(patch, fail) {
+			f, ok := jsonField(v, name)
+			if !ok {
+				continue
+			}
+			mergeInto(f, member, concat(pointer, "/", pointerEscaper.Replace(name)))
+		}
+	default:
+		fail("can not merge an object into " + v.Type().String())
+	}
+}
+
+func decodeMembers(patch json.RawMessage, fail func(reason string)) map[string]json.RawMessage {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &members); err != nil {
+		fail(err.Error())
+	}
+	return members
+}
+
+// jsonField finds the settable field of struct v named name in JSON,
+// it matches the field names case-insensitively as encoding/json does.
+func jsonField(v reflect.Value, name string) (reflect.Value, bool) {
+	var folded reflect.Value
+	for i := 0; i < v.NumField(); i++ {
+		field, f := v.Type().Field(i), v.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue
+		}
+		step := PathStep{kind: FieldStep, name: field.Name, index: i, owner: v.Type(), typ: field.Type}
+		token, ok := step.jsonToken()
+		if !ok {
+			if f.Kind() != reflect.Ptr {
+				if embedded, ok := jsonField(f, name); ok {
+					return embedded, true
+				}
+				continue
+			}
+			elem := f
+			if f.IsNil() {
+				elem = reflect.New(f.Type().Elem())
+			}
+			if embedded, ok := jsonField(elem.Elem(), name); ok {
+				f.Set(elem)
+				return embedded, true
+			}
+			continue
+		}
+		if token == name {
+			return f, true
+		}
+		if !folded.IsValid() && strings.EqualFold(token, name) {
+			folded = f
+		}
+	}
+	return folded, folded.IsValid()
+}
+
+// mergePatchValue merges the decoded patch into the decoded target as RFC 7386 describes.
+func mergePatchValue(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{}, len(p))
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatchValue(t[k], v)
+	}
+	return t
+}
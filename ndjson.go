@@ -0,0 +1,239 @@
+package sdiffer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// RecordDiff is the comparison result of a pair of NDJSON records.
+type RecordDiff struct {
+	// IndexA and IndexB are the indexes of the records in A and B, -1 if the record is missing.
+	IndexA int
+	IndexB int
+	// Key is the value of the key field, it's nil if the records are matched by their indexes.
+	Key interface{}
+	// Kind is Added if the record only exists in B, Removed if it only exists in A, otherwise Changed.
+	Kind DiffKind
+	// Diffs are the diffs between the two records, named by JSON Pointers inside the records.
+	Diffs []*Diff
+}
+
+// NDJSONResult is the result of CompareNDJSON.
+type NDJSONResult struct {
+	// Records are the records which are changed, added or removed, in the order of A,
+	// followed by the records only exist in B.
+	Records []*RecordDiff
+	// RecordsA and RecordsB are the numbers of the records in A and B.
+	RecordsA int
+	RecordsB int
+	// Equal, Changed, Added and Removed are the numbers of the records in each state.
+	Equal   int
+	Changed int
+	Added   int
+	Removed int
+}
+
+// CompareNDJSON compares two newline-delimited JSON streams record by record,
+// each pair of records is compared like CompareJSON with the rules of d.
+//
+// If key is empty, records are matched by their indexes. Otherwise, key is a JSON Pointer
+// such as /id, and records are matched by the values it points to, which must exist and be
+// unique in each stream, the records of B are buffered in memory in that case.
+//
+// The diffs of d are reset before each pair of records is compared, and the diffs of the
+// last pair are left in d.
+func (d *Differ) CompareNDJSON(a, b io.Reader, key string) (*NDJSONResult, error) {
+	decA, decB := newNDJSONDecoder(a), newNDJSONDecoder(b)
+	if isStringBlank(key) {
+		return d.compareRecordsByIndex(decA, decB)
+	}
+	return d.compareRecordsByKey(decA, decB, key)
+}
+
+func (d *Differ) compareRecordsByIndex(decA, decB *json.Decoder) (*NDJSONResult, error) {
+	result := &NDJSONResult{}
+	for i := 0; ; i++ {
+		recA, okA, err := nextRecord(decA, "A", i)
+		if err != nil {
+			return nil, err
+		}
+		recB, okB, err := nextRecord(decB, "B", i)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case okA && okB:
+			if err = d.compareRecords(result, recA, recB, i, i, nil); err != nil {
+				return nil, err
+			}
+		case okA:
+			result.addMissing(i, -1, nil)
+		case okB:
+			result.addMissing(-1, i, nil)
+		default:
+			return result, nil
+		}
+	}
+}
+
+type keyedRecord struct {
+	index  int
+	key    interface{}
+	record interface{}
+}
+
+func (d *Differ) compareRecordsByKey(decA, decB *json.Decoder, key string) (*NDJSONResult, error) {
+	recordsB := make(map[string]*keyedRecord)
+	orderB := make([]string, 0)
+	for i := 0; ; i++ {
+		rec, ok, err := nextRecord(decB, "B", i)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		kr, id, err := newKeyedRecord(rec, key, "B", i)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := recordsB[id]; dup {
+			return nil, fmt.Errorf("duplicated key %s of record %d in B", id, i)
+		}
+		recordsB[id] = kr
+		orderB = append(orderB, id)
+	}
+
+	result := &NDJSONResult{}
+	seenA := make(map[string]bool)
+	for i := 0; ; i++ {
+		rec, ok, err := nextRecord(decA, "A", i)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		kr, id, err := newKeyedRecord(rec, key, "A", i)
+		if err != nil {
+			return nil, err
+		}
+		if seenA[id] {
+			return nil, fmt.Errorf("duplicated key %s of record %d in A", id, i)
+		}
+		seenA[id] = true
+		krB, ok := recordsB[id]
+		if !ok {
+			result.addMissing(i, -1, kr.key)
+			continue
+		}
+		if err = d.compareRecords(result, kr.record, krB.record, i, krB.index, kr.key); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range orderB {
+		if kr := recordsB[id]; !seenA[id] {
+			result.addMissing(-1, kr.index, kr.key)
+		}
+	}
+	return result, nil
+}
+
+func (d *Differ) compareRecords(result *NDJSONResult, recA, recB interface{}, indexA, indexB int, key interface{}) error {
+	d.ResetDiffs()
+	if err := d.compareJSONValues(recA, recB); err != nil {
+		return fmt.Errorf("record %d of A and record %d of B: %w", indexA, indexB, err)
+	}
+	result.RecordsA++
+	result.RecordsB++
+	diffs := d.Diffs()
+	if len(diffs) == 0 {
+		result.Equal++
+		return nil
+	}
+	result.Changed++
+	result.Records = append(result.Records, &RecordDiff{
+		IndexA: indexA,
+		IndexB: indexB,
+		Key:    key,
+		Kind:   Changed,
+		Diffs:  diffs,
+	})
+	return nil
+}
+
+func (r *NDJSONResult) addMissing(indexA, indexB int, key interface{}) {
+	kind := Removed
+	if indexA < 0 {
+		kind = Added
+		r.Added++
+		r.RecordsB++
+	} else {
+		r.Removed++
+		r.RecordsA++
+	}
+	r.Records = append(r.Records, &RecordDiff{IndexA: indexA, IndexB: indexB, Key: key, Kind: kind})
+}
+
+func newNDJSONDecoder(r io.Reader) *json.Decoder {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return dec
+}
+
+// nextRecord decodes the next record, ok is false if there are no more records.
+func nextRecord(dec *json.Decoder, side string, index int) (record interface{}, ok bool, err error) {
+	if err = dec.Decode(&record); err == io.EOF {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("record %d of %s: %w", index, side, err)
+	}
+	return record, true, nil
+}
+
+func newKeyedRecord(record interface{}, key, side string, index int) (*keyedRecord, string, error) {
+	v, ok := lookupJSONPointer(record, key)
+	if !ok {
+		return nil, "", fmt.Errorf("key %q not found in record %d of %s", key, index, side)
+	}
+	// the encoded key identifies the record, so that "1" and 1 are different keys
+	id, err := json.Marshal(v)
+	if err != nil {
+		return nil, "", err
+	}
+	return &keyedRecord{index: index, key: v, record: record}, string(id), nil
+}
+
+// lookupJSONPointer returns the value pointed by pointer in a decoded JSON document.
+func lookupJSONPointer(doc interface{}, pointer string) (interface{}, bool) {
+	if pointer == "" {
+		return doc, true
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, false
+	}
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = pointerUnescaper.Replace(token)
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			elem, ok := v[token]
+			if !ok {
+				return nil, false
+			}
+			doc = elem
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
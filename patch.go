@@ -0,0 +1,231 @@
+package sdiffer
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	opAdd     = "add"
+	opRemove  = "remove"
+	opReplace = "replace"
+)
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// PatchOp is an operation of a JSON Patch document, see RFC 6902.
+type PatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON omits the value of remove operations, and keeps the null value of the others.
+func (op PatchOp) MarshalJSON() ([]byte, error) {
+	if op.Op == opRemove {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{op.Op, op.Path})
+	}
+	type patchOpJSON PatchOp
+	return json.Marshal(patchOpJSON(op))
+}
+
+// PatchOps converts the diffs into the operations of a JSON Patch document which turns A into B.
+//
+// The paths of the operations are JSON Pointers built from the json tags of the struct fields,
+// diffs of fields ignored by encoding/json are dropped, such as unexported fields. Slices which
+// are compared by a Sorter or WithSliceKey and values compared by a customized Comparator are
+// replaced as a whole, while diffs of pointer cycles are dropped.
+func (d *Differ) PatchOps() []PatchOp {
+	return d.result().PatchOps()
+}
+
+// JSONPatch returns the JSON Patch document built by PatchOps.
+func (d *Differ) JSONPatch() ([]byte, error) {
+	return d.result().JSONPatch()
+}
+
+// PatchOps converts the diffs into JSON Patch operations, see Differ.PatchOps.
+func (r *Result) PatchOps() []PatchOp {
+	ops := buildPatchOps(r.diffList)
+	patch := make([]PatchOp, 0, len(ops))
+	for _, op := range ops {
+		pointer, ok := op.path.jsonPointer()
+		if !ok {
+			continue
+		}
+		patch = append(patch, PatchOp{Op: op.op, Path: pointer, Value: op.value})
+	}
+	return patch
+}
+
+// JSONPatch returns the JSON Patch document built by PatchOps.
+func (r *Result) JSONPatch() ([]byte, error) {
+	return json.Marshal(r.PatchOps())
+}
+
+type patchOp struct {
+	op    string
+	path  Path
+	value interface{}
+}
+
+// buildPatchOps converts diffs into patch operations, the operations are sorted so that they
+// can be applied one by one.
+func buildPatchOps(diffs []*Diff) []patchOp {
+	ops := make([]patchOp, 0, len(diffs))
+	replaced := make(map[string]bool)
+	for _, df := range diffs {
+		if p, ok := replacedPath(df); ok {
+			if name := p.String(); !replaced[name] {
+				replaced[name] = true
+				_, vb := p.Last().Values()
+				ops = append(ops, patchOp{op: opReplace, path: p, value: valueInterface(vb)})
+			}
+			continue
+		}
+		switch df.kind {
+		case CycleMismatch:
+			// pointer cycles can not be represented in JSON.
+			continue
+		case CustomComparator:
+			// the comparator only tells the values are different, so the value is replaced as a whole.
+			ops = append(ops, patchOp{op: opReplace, path: df.path, value: df.b})
+		case Added:
+			ops = append(ops, patchOp{op: opAdd, path: df.path, value: df.b})
+		case Removed:
+			ops = append(ops, patchOp{op: opRemove, path: df.path})
+		case LengthMismatch:
+			ops = append(ops, lengthPatchOps(df.path)...)
+		default:
+			ops = append(ops, patchOp{op: opReplace, path: df.path, value: df.b})
+		}
+	}
+
+	// operations on shallower paths go first, since the indexes of the deeper ones are
+	// their indexes in B. Elements are removed from the tail, and added from the head.
+	phases := map[string]int{opRemove: 0, opAdd: 1, opReplace: 2}
+	sort.SliceStable(ops, func(i, j int) bool {
+		oi, oj := ops[i], ops[j]
+		if len(oi.path) != len(oj.path) {
+			return len(oi.path) < len(oj.path)
+		}
+		if oi.op != oj.op {
+			return phases[oi.op] < phases[oj.op]
+		}
+		switch oi.op {
+		case opRemove:
+			return oi.path.Last().index > oj.path.Last().index
+		case opAdd:
+			return oi.path.Last().index < oj.path.Last().index
+		}
+		return false
+	})
+	return ops
+}
+
+// replacedPath returns the path of the outermost value which contains the diff, and has to be
+// replaced as a whole, such as a slice whose elements are not compared by their original
+// indexes, or a value which is compared after being transformed.
+func replacedPath(df *Diff) (Path, bool) {
+	for k := 1; k < len(df.path); k++ {
+		s := df.path[k]
+		if s.kind == SliceKeyStep || s.kind == TransformStep || (s.kind == IndexStep && df.path[k-1].sorted) {
+			return df.path[:k], true
+		}
+	}
+	if df.kind == LengthMismatch && df.path.Last().sorted {
+		return df.path, true
+	}
+	return nil, false
+}
+
+// lengthPatchOps adds or removes the tail elements of the slice at path.
+func lengthPatchOps(path Path) (ops []patchOp) {
+	va, vb := path.Last().Values()
+	if va.Kind() != reflect.Slice {
+		return
+	}
+	for i := va.Len() - 1; i >= vb.Len(); i-- {
+		ops = append(ops, patchOp{op: opRemove, path: path.clip().next(PathStep{kind: IndexStep, index: i}, va.Index(i), reflect.Value{})})
+	}
+	for i := va.Len(); i < vb.Len(); i++ {
+		ops = append(ops, patchOp{op: opAdd, path: path.clip().next(PathStep{kind: IndexStep, index: i}, reflect.Value{}, vb.Index(i)),
+			value: valueInterface(vb.Index(i))})
+	}
+	return
+}
+
+// JSONPointer returns the JSON Pointer of p, see RFC 6901.
+// Struct fields are named by their json tags, fields ignored by encoding/json keep their own names.
+func (p Path) JSONPointer() string {
+	builder := &strings.Builder{}
+	for _, s := range p {
+		if token, ok := s.jsonToken(); ok {
+			builder.WriteString("/")
+			builder.WriteString(pointerEscaper.Replace(token))
+		}
+	}
+	return builder.String()
+}
+
+// jsonPointer is like JSONPointer, but ok is false if p contains a field ignored by encoding/json.
+func (p Path) jsonPointer() (pointer string, ok bool) {
+	for _, s := range p {
+		if s.jsonIgnored() {
+			return "", false
+		}
+	}
+	return p.JSONPointer(), true
+}
+
+// jsonIgnored checks if s is a field ignored by encoding/json, which is tagged with "-", or is
+// unexported and not an embedded struct whose fields are promoted.
+func (s PathStep) jsonIgnored() bool {
+	if s.kind != FieldStep {
+		return false
+	}
+	field := s.structField()
+	if field.Tag.Get("json") == "-" {
+		return true
+	}
+	return field.PkgPath != "" && !(field.Anonymous && field.Type.Kind() == reflect.Struct)
+}
+
+// jsonToken returns the reference token of s in a JSON Pointer, ok is false if s has no token.
+func (s PathStep) jsonToken() (token string, ok bool) {
+	switch s.kind {
+	case FieldStep:
+		field := s.structField()
+		name := field.Tag.Get("json")
+		if idx := strings.Index(name, ","); idx >= 0 {
+			name = name[:idx]
+		}
+		if name != "" && name != "-" {
+			return name, true
+		}
+		// fields of an embedded struct are promoted by encoding/json.
+		if field.Anonymous && s.typ != nil && indirectType(s.typ).Kind() == reflect.Struct {
+			return "", false
+		}
+		return s.name, true
+	case IndexStep, SliceKeyStep:
+		return strconv.Itoa(s.index), true
+	case MapKeyStep:
+		return toString(s.key), true
+	}
+	return "", false
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,242 @@
+package sdiffer
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// StepKind describes how a PathStep reaches its value from the parent value.
+type StepKind int
+
+const (
+	// RootStep is the first step of a Path, its name is the type name of the compared values.
+	RootStep StepKind = iota
+
+	// FieldStep accesses a struct field.
+	FieldStep
+
+	// IndexStep accesses an element of a slice or an array.
+	IndexStep
+
+	// MapKeyStep accesses a value of a map.
+	MapKeyStep
+
+	// PtrStep dereferences a pointer.
+	PtrStep
+
+	// TypeAssertStep asserts the dynamic type of an interface.
+	TypeAssertStep
+
+	// SliceKeyStep accesses an element of a slice by its key, see Differ.WithSliceKey.
+	SliceKeyStep
+
+	// TransformStep transforms a value with a transformer, see Differ.WithTransform.
+	TransformStep
+)
+
+var stepKindNames = [...]string{
+	RootStep:       "RootStep",
+	FieldStep:      "FieldStep",
+	IndexStep:      "IndexStep",
+	MapKeyStep:     "MapKeyStep",
+	PtrStep:        "PtrStep",
+	TypeAssertStep: "TypeAssertStep",
+	SliceKeyStep:   "SliceKeyStep",
+	TransformStep:  "TransformStep",
+}
+
+func (k StepKind) String() string {
+	if k >= 0 && int(k) < len(stepKindNames) {
+		return stepKindNames[k]
+	}
+	return fmt.Sprintf("StepKind(%d)", int(k))
+}
+
+var keyEscaper = strings.NewReplacer(`\`, `\\`, `.`, `\.`, `[`, `\[`, `]`, `\]`)
+
+// PathStep is a single step of a Path.
+type PathStep struct {
+	kind  StepKind
+	name  string
+	index int
+	key   interface{}
+	typ   reflect.Type
+	va    reflect.Value
+	vb    reflect.Value
+	// owner is the struct type holding the field of a FieldStep, whose index is the field index.
+	owner reflect.Type
+	// sorted means the slice reached by the step is sorted by a Sorter before comparison,
+	// so the indexes of its elements are not their original indexes.
+	sorted bool
+	// plan is the compiled plan of the path ending with the step.
+	plan *plan
+}
+
+// Kind returns the kind of the step.
+func (s PathStep) Kind() StepKind {
+	return s.kind
+}
+
+// Name returns the field name of a FieldStep, the type name of a RootStep,
+// or the key name of a SliceKeyStep.
+func (s PathStep) Name() string {
+	return s.name
+}
+
+// Index returns the index of an IndexStep, or the index of the field in its struct for a FieldStep.
+// For a SliceKeyStep, it's the index in B, or the index in A if the element only exists in A.
+func (s PathStep) Index() int {
+	return s.index
+}
+
+// Key returns the original map key of a MapKeyStep, or the key of a SliceKeyStep.
+func (s PathStep) Key() interface{} {
+	return s.key
+}
+
+// Type returns the type of the values reached by the step, it's nil for steps built by hand.
+func (s PathStep) Type() reflect.Type {
+	return s.typ
+}
+
+// Values returns the values of A and B reached by the step, they are invalid for steps built by hand.
+func (s PathStep) Values() (a, b reflect.Value) {
+	return s.va, s.vb
+}
+
+// String returns the canonical form of the step.
+// PtrStep, TypeAssertStep and TransformStep are invisible in the canonical form, and a FieldStep uses
+// the name in the sdiffer tag of its field if there is one.
+func (s PathStep) String() string {
+	switch s.kind {
+	case RootStep:
+		return s.name
+	case FieldStep:
+		return concat(".", s.displayName())
+	case IndexStep:
+		return concat("[", strconv.Itoa(s.index), "]")
+	case MapKeyStep:
+		return concat("[", keyEscaper.Replace(toString(s.key)), "]")
+	case SliceKeyStep:
+		return concat("[", s.name, "=", keyEscaper.Replace(toString(s.key)), "]")
+	}
+	return ""
+}
+
+// Path describes how to reach a value from the root of the compared values.
+//
+// For example:
+// NewPath("Person").Field("Parents").Index(0).Field("Name") => Person.Parents[0].Name
+type Path []PathStep
+
+// NewPath returns a Path which only contains a RootStep.
+func NewPath(root string) Path {
+	return Path{{kind: RootStep, name: root}}
+}
+
+// Field returns a copy of p with a FieldStep appended.
+func (p Path) Field(name string) Path {
+	return p.push(PathStep{kind: FieldStep, name: name})
+}
+
+// Index returns a copy of p with an IndexStep appended.
+func (p Path) Index(i int) Path {
+	return p.push(PathStep{kind: IndexStep, index: i})
+}
+
+// Key returns a copy of p with a MapKeyStep appended.
+func (p Path) Key(key interface{}) Path {
+	return p.push(PathStep{kind: MapKeyStep, key: key})
+}
+
+// SliceKey returns a copy of p with a SliceKeyStep appended.
+func (p Path) SliceKey(name string, key interface{}) Path {
+	return p.push(PathStep{kind: SliceKeyStep, name: name, key: key})
+}
+
+// Last returns the last step of p, it's a zero RootStep if p is empty.
+func (p Path) Last() PathStep {
+	if len(p) == 0 {
+		return PathStep{}
+	}
+	return p[len(p)-1]
+}
+
+// String returns the canonical form of p, which is what the regexp rules of Differ match against.
+// Special characters in map keys, such as '.', '[' and ']', are escaped with '\'.
+func (p Path) String() string {
+	builder := &strings.Builder{}
+	for _, s := range p {
+		builder.WriteString(s.String())
+	}
+	return builder.String()
+}
+
+// Equal checks if p and other have the same canonical form.
+func (p Path) Equal(other Path) bool {
+	return p.String() == other.String()
+}
+
+// push returns a copy of p with s appended, p itself is never modified.
+func (p Path) push(s PathStep) Path {
+	return append(p.clip(), s)
+}
+
+// stackDepth is the initial capacity of the path built during a comparison, which is enough
+// for most values, so that next rarely needs to allocate.
+const stackDepth = 32
+
+// newStack returns an empty path to be appended to by next.
+func newStack() Path {
+	return make(Path, 0, stackDepth)
+}
+
+// next appends s to p during the comparison, s reaches the values a and b.
+// The result shares its backing array with p, so the paths of siblings overwrite each other,
+// a path which outlives the step it was built for has to be cloned first.
+func (p Path) next(s PathStep, a, b reflect.Value) Path {
+	s.va, s.vb = a, b
+	if a.IsValid() {
+		s.typ = a.Type()
+	} else if b.IsValid() {
+		s.typ = b.Type()
+	}
+	return append(p, s)
+}
+
+// clip limits the capacity of p to its length, so that appending to it never modifies p.
+func (p Path) clip() Path {
+	return p[:len(p):len(p)]
+}
+
+// clone returns a copy of p which does not share its backing array, its capacity is its length.
+func (p Path) clone() Path {
+	q := make(Path, len(p))
+	copy(q, p)
+	return q
+}
+
+// structField returns the struct field of a FieldStep, it's zero for steps built by hand.
+func (s PathStep) structField() reflect.StructField {
+	if s.owner == nil {
+		return reflect.StructField{}
+	}
+	return structFields(s.owner)[s.index]
+}
+
+// sorted returns a copy of p whose last step is marked as sorted, it keeps the capacity of p
+// for next.
+func (p Path) sorted() (q Path) {
+	q = append(make(Path, 0, cap(p)), p...)
+	q[len(q)-1].sorted = true
+	return q
+}
+
+// PathMatcher can be implemented by a Comparator or a Sorter to match against
+// the structured Path instead of the field path string.
+// The Path is only valid during the call, copy it to keep it.
+type PathMatcher interface {
+	MatchPath(p Path) bool
+}
@@ -0,0 +1,482 @@
+package sdiffer
+
+import (
+	"reflect"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxCachedPlans limits the number of plans cached by a planCache, since recursive types can
+// make the number of distinct paths unbounded.
+const maxCachedPlans = 1 << 16
+
+// plan is the compiled comparison plan of a path, it caches the rules which match the path,
+// so that the regexps of the rules are run only once for each distinct path.
+//
+// The plans are cached by their parent plans and the steps from them, without the indexes and
+// the keys of the elements of slices, arrays and maps. The elements share a generic plan unless
+// the rules may tell them apart by their field paths, see indexBlind, then the plan of an element
+// is compiled for each element and never cached, and neither are the plans of its descendants.
+type plan struct {
+	key planKey
+	// children are the cached plans of the children, it's a []*plan replaced as a whole
+	// when a child is added.
+	children atomic.Value
+	// fieldPath is the field path of the path, it's empty for a generic plan.
+	fieldPath string
+	// generic means the plan is shared by the elements of a slice, an array or a map,
+	// or by the descendants of them.
+	generic bool
+	// transient means the plan belongs to a single element or a descendant of it,
+	// so it's not cached.
+	transient bool
+	// comparators are the candidate comparators of the path in order, the ones implementing
+	// PathMatcher or TypeMatcher have to be checked during the comparison, the others always match.
+	comparators []Comparator
+	// sorters are the candidate sorters of the path, like comparators.
+	sorters    []Sorter
+	sliceKey   *sliceKey
+	editScript bool
+	// trim trims the strings before comparison, it's nil if no trim rule matches the path.
+	trim func(s string) string
+	// ignored means the path matches an ignore rule.
+	ignored bool
+	// included means the path or one of its ancestors matches an include rule.
+	included bool
+	// excluded means neither the path nor its descendants can match an include rule.
+	excluded bool
+	// tag is the sdiffer tag of the field reached by the path, or of the field holding the elements
+	// reached by the path, direct means the path reaches the field itself.
+	tag    *fieldTag
+	direct bool
+	// tolerance is the max difference of the floats which are treated as equal.
+	tolerance float64
+	// transform is the transformer of the path, transformed means the value reached by the path
+	// is a transformed one, which is not transformed again.
+	transform   func(v interface{}) interface{}
+	transformed bool
+}
+
+// planKey identifies a plan by the step from its parent plan,
+// the owner and the index identify the field of a FieldStep.
+type planKey struct {
+	kind  StepKind
+	name  string
+	owner reflect.Type
+	index int
+}
+
+// planCache caches the plans of a rule set, it's safe for concurrent use.
+// The plans of the roots are cached by the cache, and the others by their parent plans.
+type planCache struct {
+	mu    sync.Mutex
+	roots map[planKey]*plan
+	size  int
+	// byFieldPath means some rules match the field paths, so the elements of maps can't share
+	// a plan, byIndex means some rules may tell the elements of slices and arrays apart as well.
+	byFieldPath bool
+	byIndex     bool
+}
+
+func newPlanCache(r *rules) *planCache {
+	c := &planCache{roots: make(map[planKey]*plan)}
+	c.byFieldPath, c.byIndex = r.matchFieldPaths()
+	return c
+}
+
+// matchFieldPaths checks if any rule of r matches the field paths, and if any of them may tell
+// the indexes apart. The comparators and the sorters which implement PathMatcher or TypeMatcher
+// are matched during the comparison.
+func (r *rules) matchFieldPaths() (byFieldPath, byIndex bool) {
+	var regexps []*regexp.Regexp
+	regexps = append(regexps, r.ignores...)
+	regexps = append(regexps, r.includes...)
+	regexps = append(regexps, r.trimSpaces...)
+	regexps = append(regexps, r.editScripts...)
+	for _, sk := range r.sliceKeys {
+		regexps = append(regexps, sk.fieldRegexp)
+	}
+	for _, tt := range r.trimTags {
+		regexps = append(regexps, tt.fieldRegexp)
+	}
+	for _, t := range r.transforms {
+		regexps = append(regexps, t.fieldRegexp)
+	}
+	byFieldPath = len(regexps) > 0
+	for _, re := range regexps {
+		if !indexBlind(re) {
+			byIndex = true
+			break
+		}
+	}
+	for _, c := range r.comparators {
+		if !isDynamicMatcher(c) {
+			return true, true
+		}
+	}
+	for _, s := range r.sorters {
+		if !isDynamicMatcher(s) {
+			return true, true
+		}
+	}
+	return
+}
+
+// indexBlind checks if r matches the field paths which only differ in the indexes alike.
+// It's true if r matches no digit and no bracket, except by repeating any character.
+func indexBlind(r *regexp.Regexp) bool {
+	re, err := syntax.Parse(r.String(), syntax.Perl)
+	if err != nil {
+		return false
+	}
+	return blindSyntax(re.Simplify())
+}
+
+func blindSyntax(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpNoMatch, syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine,
+		syntax.OpBeginText, syntax.OpEndText:
+		return true
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if isIndexRune(r) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpCharClass:
+		for _, r := range indexRunes {
+			if classContains(re, r) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpStar, syntax.OpPlus:
+		// a repetition matching all the index runes spans the whole index.
+		sub := re.Sub[0]
+		if sub.Op == syntax.OpAnyChar || sub.Op == syntax.OpAnyCharNotNL ||
+			sub.Op == syntax.OpCharClass && classContainsAll(sub, indexRunes) {
+			return true
+		}
+		return blindSyntax(sub)
+	case syntax.OpQuest, syntax.OpRepeat, syntax.OpConcat, syntax.OpAlternate, syntax.OpCapture:
+		for _, sub := range re.Sub {
+			if !blindSyntax(sub) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// indexRunes are the runes which may appear in the index of a field path, such as [12] or /12.
+var indexRunes = []rune("0123456789[]")
+
+func isIndexRune(r rune) bool {
+	return r >= '0' && r <= '9' || r == '[' || r == ']'
+}
+
+// classContains checks if the character class re contains r.
+func classContains(re *syntax.Regexp, r rune) bool {
+	for i := 0; i < len(re.Rune); i += 2 {
+		if re.Rune[i] <= r && r <= re.Rune[i+1] {
+			return true
+		}
+	}
+	return false
+}
+
+func classContainsAll(re *syntax.Regexp, runes []rune) bool {
+	for _, r := range runes {
+		if !classContains(re, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// load returns the cached plan reached by the step of key from plan parent, it's nil if
+// there is none. The children of a plan are read without locking.
+func (c *planCache) load(parent *plan, key planKey) *plan {
+	if parent == nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.roots[key]
+	}
+	children, _ := parent.children.Load().([]*plan)
+	// the fields are mostly cached in order.
+	if i := key.index; key.kind == FieldStep && i < len(children) && children[i].key == key {
+		return children[i]
+	}
+	for _, child := range children {
+		if child.key == key {
+			return child
+		}
+	}
+	return nil
+}
+
+// store caches plan p reached by the step of key from plan parent.
+func (c *planCache) store(parent *plan, key planKey, p *plan) {
+	p.key = key
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size >= maxCachedPlans {
+		return
+	}
+	c.size++
+	if parent == nil {
+		c.roots[key] = p
+		return
+	}
+	children, _ := parent.children.Load().([]*plan)
+	parent.children.Store(append(children[:len(children):len(children)], p))
+}
+
+// planOf returns the plan of path, which is cached in the last step of path.
+func (d *Differ) planOf(path Path) *plan {
+	last := &path[len(path)-1]
+	if last.plan != nil {
+		return last.plan
+	}
+	if d.plans == nil {
+		d.plans = newPlanCache(&d.rules)
+	}
+	var parent *plan
+	key := planKey{kind: last.kind, name: last.name}
+	switch {
+	case len(path) > 1:
+		parent = d.planOf(path[:len(path)-1])
+	case d.jsonPaths:
+		key.index = 1
+	}
+	element := last.kind == IndexStep || last.kind == MapKeyStep || last.kind == SliceKeyStep
+	perElement := element && d.plans.byFieldPath && (last.kind != IndexStep || d.plans.byIndex)
+	if parent != nil && parent.transient || perElement {
+		// a pointer or an interface has the field path and the tag of its value.
+		if parent != nil && parent.transient && (last.kind == PtrStep || last.kind == TypeAssertStep) {
+			last.plan = parent
+			return parent
+		}
+		p := d.compilePlan(path, parent, false)
+		p.transient = true
+		last.plan = p
+		return p
+	}
+	if last.kind == FieldStep {
+		key.owner, key.index = last.owner, last.index
+	}
+	p := d.plans.load(parent, key)
+	if p == nil {
+		p = d.compilePlan(path, parent, element)
+		d.plans.store(parent, key, p)
+	}
+	last.plan = p
+	return p
+}
+
+// compilePlan matches the rules of d against path, parent is the plan of the parent path,
+// shared means the last step of path reaches an element sharing the plan with the others.
+func (d *Differ) compilePlan(path Path, parent *plan, shared bool) *plan {
+	p := &plan{generic: shared || parent != nil && parent.generic}
+	var fieldPath string
+	switch {
+	case !p.generic:
+		fieldPath = d.stepPath(parent, path.Last())
+		p.fieldPath = fieldPath
+	case d.plans.byFieldPath:
+		// the rules match the field paths of all the elements alike.
+		fieldPath = d.pathString(path)
+	}
+	p.ignored = matchAny(d.ignores, fieldPath)
+	p.included = parent != nil && parent.included || matchAny(d.includes, fieldPath)
+	p.excluded = !p.included
+	for _, ic := range d.includes {
+		if mayMatchDescendant(ic, fieldPath) {
+			p.excluded = false
+			break
+		}
+	}
+	for _, c := range d.comparators {
+		if isDynamicMatcher(c) {
+			p.comparators = append(p.comparators, c)
+		} else if c.Match(fieldPath) {
+			p.comparators = append(p.comparators, c)
+			break
+		}
+	}
+	for _, s := range d.sorters {
+		if isDynamicMatcher(s) {
+			p.sorters = append(p.sorters, s)
+		} else if s.Match(fieldPath) {
+			p.sorters = append(p.sorters, s)
+			break
+		}
+	}
+	for _, sk := range d.sliceKeys {
+		if sk.fieldRegexp.MatchString(fieldPath) {
+			p.sliceKey = sk
+			break
+		}
+	}
+	p.editScript = matchAny(d.editScripts, fieldPath)
+	if matchAny(d.trimSpaces, fieldPath) {
+		p.trim = strings.TrimSpace
+	} else {
+		for _, tt := range d.trimTags {
+			if tt.fieldRegexp.MatchString(fieldPath) {
+				p.trim = tt.Trim
+				break
+			}
+		}
+	}
+	compileTag(p, path.Last(), parent)
+	kind := path.Last().kind
+	p.transformed = kind == TransformStep ||
+		parent != nil && parent.transformed && (kind == PtrStep || kind == TypeAssertStep)
+	if !p.transformed {
+		for _, t := range d.transforms {
+			if t.fieldRegexp.MatchString(fieldPath) {
+				p.transform = t.fn
+				break
+			}
+		}
+	}
+	return p
+}
+
+// stepPath returns the field path reached by step s from the path of plan parent.
+func (d *Differ) stepPath(parent *plan, s PathStep) string {
+	var prefix string
+	if parent != nil {
+		prefix = parent.fieldPath
+	}
+	if !d.jsonPaths {
+		// the common steps are joined with the prefix at once.
+		switch s.kind {
+		case FieldStep:
+			return prefix + "." + s.displayName()
+		case IndexStep:
+			return prefix + "[" + strconv.Itoa(s.index) + "]"
+		}
+		if str := s.String(); str != "" {
+			return concat(prefix, str)
+		}
+		return prefix
+	}
+	if token, ok := s.jsonToken(); ok {
+		return concat(prefix, "/", pointerEscaper.Replace(token))
+	}
+	return prefix
+}
+
+// compileTag applies the sdiffer tag of the field reached by the last step onto p, the rules
+// of Differ take precedence over the tag.
+func compileTag(p *plan, last PathStep, parent *plan) {
+	switch {
+	case last.kind == FieldStep:
+		tag, err := tagOf(last.structField())
+		if err != nil {
+			throw(err)
+		}
+		p.tag, p.direct = tag, true
+	case parent != nil:
+		p.tag = parent.tag
+		p.direct = parent.direct && (last.kind == PtrStep || last.kind == TypeAssertStep)
+	}
+	tag := p.tag
+	if tag == nil {
+		return
+	}
+	if p.trim == nil && tag.trimSpace {
+		p.trim = strings.TrimSpace
+	} else if p.trim == nil && tag.hasTrim {
+		p.trim = func(s string) string {
+			return strings.Trim(s, tag.cutset)
+		}
+	}
+	if p.direct && tag.unordered {
+		p.sorters = append(p.sorters, tagSorter{})
+	}
+	p.tolerance = tag.tolerance
+}
+
+// comparatorOf returns the comparator of plan p which matches path and the type t of the compared values.
+func (d *Differ) comparatorOf(p *plan, path Path, t reflect.Type) Comparator {
+	for _, c := range p.comparators {
+		if d.matchDynamic(c, path, t) {
+			return c
+		}
+	}
+	return nil
+}
+
+// sorterOf returns the sorter of plan p which matches path and the type t of the compared slices.
+func (d *Differ) sorterOf(p *plan, path Path, t reflect.Type) Sorter {
+	for _, s := range p.sorters {
+		if d.matchDynamic(s, path, t) {
+			return s
+		}
+	}
+	return nil
+}
+
+// isDynamicMatcher checks if m has to be matched during the comparison, since it implements
+// PathMatcher or TypeMatcher.
+func isDynamicMatcher(m interface{}) bool {
+	switch m.(type) {
+	case PathMatcher, TypeMatcher:
+		return true
+	}
+	return false
+}
+
+// matchDynamic checks if m matches path and the type t, it's always true if m is not
+// a dynamic matcher, since it has matched the field path when the plan was compiled.
+func (d *Differ) matchDynamic(m interface{}, path Path, t reflect.Type) bool {
+	switch m := m.(type) {
+	case PathMatcher:
+		return m.MatchPath(path.clip())
+	case TypeMatcher:
+		return m.MatchType(d.pathString(path), t)
+	}
+	return true
+}
+
+// mayMatchDescendant checks if r may match fieldPath or the path of one of its descendants.
+// It's true unless r is anchored at the beginning and its literal prefix diverges from fieldPath.
+func mayMatchDescendant(r *regexp.Regexp, fieldPath string) bool {
+	re, err := syntax.Parse(r.String(), syntax.Perl)
+	if err != nil {
+		return true
+	}
+	re = re.Simplify()
+	anchored := re.Op == syntax.OpBeginText ||
+		re.Op == syntax.OpConcat && len(re.Sub) > 0 && re.Sub[0].Op == syntax.OpBeginText
+	if !anchored {
+		return true
+	}
+	prefix, _ := r.LiteralPrefix()
+	return strings.HasPrefix(prefix, fieldPath) || strings.HasPrefix(fieldPath, prefix)
+}
+
+// structFieldsCache caches the fields of struct types, which do not depend on the rules.
+var structFieldsCache sync.Map
+
+// structFields returns the fields of the struct type t.
+func structFields(t reflect.Type) []reflect.StructField {
+	if fields, ok := structFieldsCache.Load(t); ok {
+		return fields.([]reflect.StructField)
+	}
+	fields := make([]reflect.StructField, t.NumField())
+	for i := range fields {
+		fields[i] = t.Field(i)
+	}
+	structFieldsCache.Store(t, fields)
+	return fields
+}
@@ -0,0 +1,11 @@
+module github.com/sshelll/sdiffer/sdiffertest
+
+go 1.16
+
+require (
+	github.com/golang/mock v1.6.0
+	github.com/sshelll/sdiffer v0.0.0-00010101000000-000000000000
+	github.com/stretchr/testify v1.8.1
+)
+
+replace github.com/sshelll/sdiffer => ..
@@ -0,0 +1,52 @@
+package sdiffertest
+
+import (
+	"fmt"
+
+	"github.com/golang/mock/gomock"
+)
+
+// matcher is a gomock.Matcher which compares the arguments with sdiffer.
+type matcher struct {
+	want interface{}
+	opts []Option
+}
+
+// Matcher returns a gomock.Matcher which matches the arguments equal to want, the diff
+// report is printed when an argument does not match.
+//
+// For example:
+//
+//	repo.EXPECT().Save(sdiffertest.Matcher(user, sdiffertest.Ignore(`\.UpdatedAt$`)))
+func Matcher(want interface{}, opts ...Option) gomock.Matcher {
+	return &matcher{want: want, opts: opts}
+}
+
+func (m *matcher) Matches(x interface{}) bool {
+	report, err := diff(m.want, x, m.opts)
+	return err == nil && report == ""
+}
+
+func (m *matcher) String() string {
+	return fmt.Sprintf("is equal to %v", m.want)
+}
+
+// Got implements gomock.GotFormatter.
+func (m *matcher) Got(got interface{}) string {
+	report, err := diff(m.want, got, m.opts)
+	if err != nil {
+		return fmt.Sprintf("%v, %v", got, err)
+	}
+	return fmt.Sprintf("%v\n%s", got, report)
+}
+
+// MatchFunc returns a function which checks if its argument equals to want,
+// it can be used with mock.MatchedBy of testify.
+//
+// For example:
+//
+//	repo.On("Save", mock.MatchedBy(sdiffertest.MatchFunc(user, sdiffertest.Ignore(`\.UpdatedAt$`))))
+func MatchFunc(want interface{}, opts ...Option) func(got interface{}) bool {
+	m := &matcher{want: want, opts: opts}
+	return m.Matches
+}
@@ -0,0 +1,43 @@
+package sdiffertest
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/mock"
+)
+
+func (suite *SdiffertestTestSuite) TestMatcher() {
+	want := user{Name: "a", Age: 1}
+	m := Matcher(want, Ignore(`\.Age$`))
+	suite.True(m.Matches(user{Name: "a", Age: 2}))
+	suite.False(m.Matches(user{Name: "b"}))
+	suite.False(m.Matches(1))
+	suite.Equal("is equal to {a 1 []}", m.String())
+
+	formatter, ok := m.(gomock.GotFormatter)
+	suite.True(ok)
+	suite.Equal("{b 2 []}\n"+
+		"values are not equal, found 1 diff(s) (-want +got):\n"+
+		"  user.Name (Changed):\n"+
+		"    - want: a\n"+
+		"    + got:  b\n", formatter.Got(user{Name: "b", Age: 2}))
+	suite.Contains(formatter.Got(1), "type mismatch")
+}
+
+type repo struct {
+	mock.Mock
+}
+
+func (r *repo) Save(u user) {
+	r.Called(u)
+}
+
+func (suite *SdiffertestTestSuite) TestMatchFunc() {
+	r := &repo{}
+	r.On("Save", mock.MatchedBy(MatchFunc(user{Name: "a"}, Ignore(`\.Age$`)))).Return().Once()
+	r.Save(user{Name: "a", Age: 10})
+	r.AssertExpectations(suite.T())
+
+	match := MatchFunc(user{Name: "a"})
+	suite.False(match(user{Name: "a", Age: 1}))
+	suite.False(match("a"))
+}
@@ -0,0 +1,230 @@
+// Package sdiffertest provides test helpers which fail a test with a readable diff report.
+//
+// For example:
+//
+//	sdiffertest.AssertEqual(t, want, got, sdiffertest.Ignore(`\.UpdatedAt$`))
+package sdiffertest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sshelll/sdiffer"
+)
+
+// Option configures the Differ used by AssertEqual and RequireEqual, and how the diffs are reported.
+type Option func(o *options) error
+
+// options are filled by the Options before comparison.
+type options struct {
+	differ *sdiffer.Differ
+	// tmpl formats each diff in the report if it's set by WithTmpl.
+	tmpl string
+}
+
+// Ignore is like Differ.Ignore.
+func Ignore(regexps ...string) Option {
+	return func(o *options) error {
+		return o.differ.IgnoreE(regexps...)
+	}
+}
+
+// IgnorePath is like Differ.IgnorePath.
+func IgnorePath(fns ...func(p sdiffer.Path) bool) Option {
+	return func(o *options) error {
+		o.differ.IgnorePath(fns...)
+		return nil
+	}
+}
+
+// Includes is like Differ.Includes.
+func Includes(regexps ...string) Option {
+	return func(o *options) error {
+		return o.differ.IncludesE(regexps...)
+	}
+}
+
+// IncludesPath is like Differ.IncludesPath.
+func IncludesPath(fns ...func(p sdiffer.Path) bool) Option {
+	return func(o *options) error {
+		o.differ.IncludesPath(fns...)
+		return nil
+	}
+}
+
+// WithTrim is like Differ.WithTrim.
+func WithTrim(fieldPath string, cutset string) Option {
+	return func(o *options) error {
+		return o.differ.WithTrimE(fieldPath, cutset)
+	}
+}
+
+// WithTrimSpace is like Differ.WithTrimSpace.
+func WithTrimSpace(fieldPaths ...string) Option {
+	return func(o *options) error {
+		return o.differ.WithTrimSpaceE(fieldPaths...)
+	}
+}
+
+// WithEditScript is like Differ.WithEditScript.
+func WithEditScript(fieldPaths ...string) Option {
+	return func(o *options) error {
+		return o.differ.WithEditScriptE(fieldPaths...)
+	}
+}
+
+// WithSliceKey is like Differ.WithSliceKey.
+func WithSliceKey(fieldPath, keyName string, keyFunc func(elem interface{}) interface{}) Option {
+	return func(o *options) error {
+		return o.differ.WithSliceKeyE(fieldPath, keyName, keyFunc)
+	}
+}
+
+// WithComparator is like Differ.WithComparator.
+func WithComparator(c sdiffer.Comparator) Option {
+	return func(o *options) error {
+		o.differ.WithComparator(c)
+		return nil
+	}
+}
+
+// WithSorter is like Differ.WithSorter.
+func WithSorter(s sdiffer.Sorter) Option {
+	return func(o *options) error {
+		o.differ.WithSorter(s)
+		return nil
+	}
+}
+
+// WithMaxDepth is like Differ.WithMaxDepth.
+func WithMaxDepth(depth int) Option {
+	return func(o *options) error {
+		o.differ.WithMaxDepth(depth)
+		return nil
+	}
+}
+
+// WithTmpl is like Differ.WithTmpl, the diffs in the report are formatted by tmpl instead of
+// the -want +got lines, and sorted by their names as well.
+func WithTmpl(tmpl string) Option {
+	return func(o *options) error {
+		o.tmpl = tmpl
+		return nil
+	}
+}
+
+// AllowUnexported is like Differ.AllowUnexported.
+func AllowUnexported() Option {
+	return func(o *options) error {
+		o.differ.AllowUnexported()
+		return nil
+	}
+}
+
+// IgnoreUnexported is like Differ.IgnoreUnexported.
+func IgnoreUnexported() Option {
+	return func(o *options) error {
+		o.differ.IgnoreUnexported()
+		return nil
+	}
+}
+
+// WithPostFilter is like Differ.WithPostFilter.
+func WithPostFilter() Option {
+	return func(o *options) error {
+		o.differ.WithPostFilter()
+		return nil
+	}
+}
+
+// WithEqualMethods is like Differ.WithEqualMethods.
+func WithEqualMethods() Option {
+	return func(o *options) error {
+		o.differ.WithEqualMethods()
+		return nil
+	}
+}
+
+// IgnoreTypes is like Differ.IgnoreTypes.
+func IgnoreTypes(types ...reflect.Type) Option {
+	return func(o *options) error {
+		o.differ.IgnoreTypes(types...)
+		return nil
+	}
+}
+
+// WithTypeComparator is like Differ.WithTypeComparator.
+func WithTypeComparator(t reflect.Type, equal func(a, b interface{}) bool) Option {
+	return func(o *options) error {
+		o.differ.WithTypeComparator(t, equal)
+		return nil
+	}
+}
+
+// WithTransform is like Differ.WithTransform.
+func WithTransform(fieldPath string, fn func(v interface{}) interface{}) Option {
+	return func(o *options) error {
+		return o.differ.WithTransformE(fieldPath, fn)
+	}
+}
+
+// AssertEqual compares want and got, and marks t as failed with a diff report if they are different.
+// It returns true if they are equal.
+func AssertEqual(t testing.TB, want, got interface{}, opts ...Option) bool {
+	t.Helper()
+	report, err := diff(want, got, opts)
+	if err != nil {
+		t.Errorf("sdiffertest: %v", err)
+		return false
+	}
+	if report != "" {
+		t.Error(report)
+		return false
+	}
+	return true
+}
+
+// RequireEqual is like AssertEqual, but stops the test with t.FailNow if want and got are different.
+func RequireEqual(t testing.TB, want, got interface{}, opts ...Option) {
+	t.Helper()
+	if !AssertEqual(t, want, got, opts...) {
+		t.FailNow()
+	}
+}
+
+// diff returns the diff report of want and got, which is empty if they are equal.
+func diff(want, got interface{}, opts []Option) (string, error) {
+	o := &options{differ: sdiffer.NewDiffer()}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return "", err
+		}
+	}
+	result, err := o.differ.Config().Compare(want, got)
+	if err != nil {
+		return "", err
+	}
+	diffs := result.Diffs()
+	if len(diffs) == 0 {
+		return "", nil
+	}
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return diffs[i].Name() < diffs[j].Name()
+	})
+
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "values are not equal, found %d diff(s) (-want +got):\n", len(diffs))
+	for _, df := range diffs {
+		if o.tmpl != "" {
+			builder.WriteString(df.String(o.tmpl) + "\n")
+			continue
+		}
+		fmt.Fprintf(builder, "  %s (%s):\n", df.Name(), df.Kind())
+		fmt.Fprintf(builder, "    - want: %v\n", df.Va())
+		fmt.Fprintf(builder, "    + got:  %v\n", df.Vb())
+	}
+	return builder.String(), nil
+}
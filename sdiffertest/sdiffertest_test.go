@@ -0,0 +1,121 @@
+package sdiffertest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// fakeT records the failures instead of failing the test.
+type fakeT struct {
+	testing.TB
+	helper  bool
+	failed  bool
+	stopped bool
+	logs    []string
+}
+
+func (t *fakeT) Helper() {
+	t.helper = true
+}
+
+func (t *fakeT) Error(args ...interface{}) {
+	t.failed = true
+	t.logs = append(t.logs, fmt.Sprint(args...))
+}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.failed = true
+	t.logs = append(t.logs, fmt.Sprintf(format, args...))
+}
+
+func (t *fakeT) FailNow() {
+	t.stopped = true
+}
+
+type SdiffertestTestSuite struct {
+	suite.Suite
+}
+
+func TestSdiffertest(t *testing.T) {
+	suite.Run(t, new(SdiffertestTestSuite))
+}
+
+type user struct {
+	Name string
+	Age  int
+	Tags []string
+}
+
+func (suite *SdiffertestTestSuite) TestAssertEqual() {
+	t := &fakeT{}
+	suite.True(AssertEqual(t, user{Name: "a"}, user{Name: " a "}, WithTrimSpace(`\.Name$`)))
+	suite.False(t.failed)
+	suite.True(t.helper)
+
+	t = &fakeT{}
+	suite.False(AssertEqual(t, user{Name: "a", Age: 1, Tags: []string{"x"}}, user{Name: "b", Age: 2}, Ignore(`\.Age$`)))
+	suite.True(t.failed)
+	suite.False(t.stopped)
+	suite.Equal([]string{"values are not equal, found 2 diff(s) (-want +got):\n" +
+		"  user.Name (Changed):\n" +
+		"    - want: a\n" +
+		"    + got:  b\n" +
+		"  user.Tags (NilMismatch):\n" +
+		"    - want: <not nil>\n" +
+		"    + got:  <nil>\n"}, t.logs)
+
+	t = &fakeT{}
+	suite.False(AssertEqual(t, user{Name: "a", Age: 2, Tags: []string{}}, user{Name: "b", Age: 1},
+		WithTmpl("%s: %v => %v")))
+	suite.Equal([]string{"values are not equal, found 3 diff(s) (-want +got):\n" +
+		"user.Age: 2 => 1\n" +
+		"user.Name: a => b\n" +
+		"user.Tags: <not nil> => <nil>\n"}, t.logs)
+
+	t = &fakeT{}
+	suite.False(AssertEqual(t, user{}, user{}, Ignore("(")))
+	suite.Len(t.logs, 1)
+	suite.Contains(t.logs[0], "sdiffertest: invalid ignore rule")
+
+	t = &fakeT{}
+	suite.False(AssertEqual(t, user{}, 1))
+	suite.Contains(t.logs[0], "type mismatch")
+}
+
+func (suite *SdiffertestTestSuite) TestRequireEqual() {
+	t := &fakeT{}
+	RequireEqual(t, []int{1, 2}, []int{1, 2})
+	suite.False(t.stopped)
+
+	RequireEqual(t, []int{1, 2}, []int{1, 3}, Includes(`\[0\]`))
+	suite.False(t.stopped)
+
+	RequireEqual(t, []int{1, 2}, []int{1, 3})
+	suite.True(t.failed)
+	suite.True(t.stopped)
+}
+
+func (suite *SdiffertestTestSuite) TestOptions() {
+	want, got := user{Tags: []string{"a"}}, user{Tags: []string{"b"}}
+	suite.True(AssertEqual(&fakeT{}, want, got, Ignore(`\.Tags$`)))
+	suite.False(AssertEqual(&fakeT{}, want, got, Ignore(`\.Tags$`), WithPostFilter()))
+
+	at := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	suite.True(AssertEqual(&fakeT{}, at, at.In(time.FixedZone("UTC+1", 3600)), WithEqualMethods()))
+
+	suite.True(AssertEqual(&fakeT{}, want, got, IgnoreTypes(reflect.TypeOf([]string{}))))
+	suite.True(AssertEqual(&fakeT{}, at, at.Add(time.Millisecond), WithTypeComparator(reflect.TypeOf(at), func(a, b interface{}) bool {
+		return a.(time.Time).Unix() == b.(time.Time).Unix()
+	})))
+
+	lower := func(v interface{}) interface{} {
+		return strings.ToLower(v.(string))
+	}
+	suite.True(AssertEqual(&fakeT{}, user{Name: "A"}, user{Name: "a"}, WithTransform(`\.Name$`, lower)))
+	suite.False(AssertEqual(&fakeT{}, user{}, user{}, WithTransform("(", lower)))
+}
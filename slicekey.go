@@ -0,0 +1,70 @@
+package sdiffer
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+type sliceKey struct {
+	fieldRegexp *regexp.Regexp
+	name        string
+	keyFunc     func(elem interface{}) interface{}
+}
+
+func newSliceKey(exp, name string, keyFunc func(elem interface{}) interface{}) (*sliceKey, error) {
+	r, err := regexp.Compile(exp)
+	if err != nil {
+		return nil, &InvalidRuleError{Rule: "slice key", Expr: exp, Err: err}
+	}
+	return &sliceKey{
+		fieldRegexp: r,
+		name:        name,
+		keyFunc:     keyFunc,
+	}, nil
+}
+
+// compareByKey pairs the elements of slices a and b by their keys, paired elements are compared
+// with each other, and the others are reported as Removed or Added.
+// A *SliceKeyError is thrown if the keys are not comparable, or not unique in a slice.
+func (d *Differ) compareByKey(a, b reflect.Value, path Path, sk *sliceKey, depth int) {
+	keysOf := func(v reflect.Value) (keys []interface{}, indexes map[interface{}]int) {
+		indexes = make(map[interface{}]int, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			k := sk.keyFunc(d.interfaceOf(v.Index(i), path))
+			if k != nil && !reflect.TypeOf(k).Comparable() {
+				throw(&SliceKeyError{Path: d.pathString(path), Key: k, Reason: fmt.Sprintf("type %T is not comparable", k)})
+			}
+			if _, ok := indexes[k]; ok {
+				throw(&SliceKeyError{Path: d.pathString(path), Key: k, Reason: "duplicate key"})
+			}
+			keys = append(keys, k)
+			indexes[k] = i
+		}
+		return
+	}
+	keysA, _ := keysOf(a)
+	keysB, indexesB := keysOf(b)
+
+	paired := make([]bool, b.Len())
+	for i, k := range keysA {
+		ea := a.Index(i)
+		step := PathStep{kind: SliceKeyStep, name: sk.name, key: k, index: i}
+		if j, ok := indexesB[k]; ok {
+			paired[j] = true
+			eb := b.Index(j)
+			step.index = j
+			d.doCompare(ea, eb, path.next(step, ea, eb), depth)
+			continue
+		}
+		d.setMissingDiff(path.next(step, ea, reflect.Value{}), ea, reflect.Value{})
+	}
+	for j, k := range keysB {
+		if paired[j] {
+			continue
+		}
+		eb := b.Index(j)
+		step := PathStep{kind: SliceKeyStep, name: sk.name, key: k, index: j}
+		d.setMissingDiff(path.next(step, reflect.Value{}, eb), reflect.Value{}, eb)
+	}
+}
@@ -7,7 +7,8 @@ import (
 // Sorter sort slice before comparison to do disordered comparison.
 type Sorter interface {
 
-	// Match checks if a field should use this comparator.
+	// Match checks if a field should use this sorter.
+	// If the sorter also implements PathMatcher or TypeMatcher, MatchPath or MatchType will be used instead.
 	Match(fieldPath string) bool
 
 	// Less calculate if 'a' is less than 'b'.
@@ -29,7 +30,9 @@ func qsort(slice reflect.Value, less func(a, b interface{}) bool) {
 
 func doQsort(slice reflect.Value, less func(a, b interface{}) bool, start, end int) {
 	if start < end {
-		m := slice.Index(start).Interface()
+		pivot := reflect.New(slice.Type().Elem()).Elem()
+		pivot.Set(slice.Index(start))
+		m := pivot.Interface()
 		l, r := start, end
 		for l < r {
 			for l < r && less(m, slice.Index(r).Interface()) {
@@ -47,7 +50,7 @@ func doQsort(slice reflect.Value, less func(a, b interface{}) bool, start, end i
 				r--
 			}
 		}
-		slice.Index(l).Set(reflect.ValueOf(m))
+		slice.Index(l).Set(pivot)
 		doQsort(slice, less, start, l-1)
 		doQsort(slice, less, l+1, end)
 	}
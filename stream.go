@@ -0,0 +1,326 @@
+package sdiffer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// CompareJSONStream compares two JSON documents token by token, and calls fn with every diff
+// as soon as it is found, the diffs are not recorded by Differ.
+//
+// Both documents are walked in lockstep, so the memory used is bounded by the depth of the
+// documents, rather than their sizes. Scalars are compared as CompareJSONReader does, with
+// the same ignore, include, trim and comparator rules. Array elements are compared by their
+// indexes, and extra elements are reported as Added or Removed without a length diff.
+// Object members are expected to be in the same order, a member whose key is out of order is
+// buffered until the member with the same key is read from the other document, and compared
+// with it then, so only the out of order members are held in memory. Ignored values are skipped
+// without being decoded.
+func (d *Differ) CompareJSONStream(a, b io.Reader, fn func(df *Diff)) (err error) {
+	defer catch(&err)
+	d.jsonPaths, d.sink = true, fn
+	defer func() {
+		d.jsonPaths, d.sink = false, nil
+	}()
+	d.visitedA = make(map[visit]visitRecord)
+	d.visitedB = make(map[visit]visitRecord)
+
+	s := &jsonStream{d: d, a: json.NewDecoder(a), b: json.NewDecoder(b)}
+	s.a.UseNumber()
+	s.b.UseNumber()
+	if err = s.compare(Path{{kind: RootStep}}, 0); err != nil {
+		return err
+	}
+	for _, dec := range []*json.Decoder{s.a, s.b} {
+		if _, err = dec.Token(); err != io.EOF {
+			return fmt.Errorf("invalid character after top-level value at offset %d", dec.InputOffset())
+		}
+	}
+	return nil
+}
+
+type jsonStream struct {
+	d *Differ
+	a *json.Decoder
+	b *json.Decoder
+}
+
+// compare compares the next values of the two documents, the values are skipped if the
+// path is pruned by the ignore or include rules.
+func (s *jsonStream) compare(path Path, depth int) error {
+	if s.d.isPruned(s.d.planOf(path), path) {
+		if err := skipValue(s.a); err != nil {
+			return err
+		}
+		return skipValue(s.b)
+	}
+	ta, err := s.a.Token()
+	if err != nil {
+		return err
+	}
+	tb, err := s.b.Token()
+	if err != nil {
+		return err
+	}
+	if da, ok := ta.(json.Delim); ok && ta == tb {
+		if depth+1 > s.d.maxDepth {
+			throw(&DepthExceededError{Path: path.JSONPointer(), MaxDepth: s.d.maxDepth})
+		}
+		if da == '{' {
+			return s.compareObject(path, depth+1)
+		}
+		return s.compareArray(path, depth+1)
+	}
+	va, err := readRest(s.a, ta)
+	if err != nil {
+		return err
+	}
+	vb, err := readRest(s.b, tb)
+	if err != nil {
+		return err
+	}
+	s.compareValues(va, vb, path, depth)
+	return nil
+}
+
+func (s *jsonStream) compareObject(path Path, depth int) error {
+	for s.a.More() && s.b.More() {
+		ka, err := readKey(s.a)
+		if err != nil {
+			return err
+		}
+		kb, err := readKey(s.b)
+		if err != nil {
+			return err
+		}
+		if ka != kb {
+			return s.compareUnordered(path, depth, ka, kb)
+		}
+		if err = s.compare(path.Key(ka), depth); err != nil {
+			return err
+		}
+	}
+	if err := s.reportRestMembers(s.a, path, true); err != nil {
+		return err
+	}
+	if err := s.reportRestMembers(s.b, path, false); err != nil {
+		return err
+	}
+	return readEnd(s.a, s.b)
+}
+
+// compareUnordered compares the rest members of the two objects whose keys have diverged at
+// ka and kb, the values of which are not read yet. A member is buffered until the member with
+// the same key is read from the other object, members whose keys line up are compared as usual.
+func (s *jsonStream) compareUnordered(path Path, depth int, ka, kb string) error {
+	pendingA, pendingB := make(map[string]interface{}), make(map[string]interface{})
+	okA, okB := true, true
+	for okA || okB {
+		if okA && okB && ka == kb {
+			if err := s.compare(path.Key(ka), depth); err != nil {
+				return err
+			}
+		} else {
+			if okA {
+				if err := s.pairMember(s.a, path.Key(ka), pendingA, pendingB, depth, true); err != nil {
+					return err
+				}
+			}
+			if okB {
+				if err := s.pairMember(s.b, path.Key(kb), pendingB, pendingA, depth, false); err != nil {
+					return err
+				}
+			}
+		}
+		var err error
+		if ka, okA, err = nextKey(s.a); err != nil {
+			return err
+		}
+		if kb, okB, err = nextKey(s.b); err != nil {
+			return err
+		}
+	}
+	for _, pending := range []struct {
+		members map[string]interface{}
+		removed bool
+	}{{pendingA, true}, {pendingB, false}} {
+		keys := make([]string, 0, len(pending.members))
+		for k := range pending.members {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s.reportMissing(path.Key(k), pending.members[k], pending.removed)
+		}
+	}
+	return readEnd(s.a, s.b)
+}
+
+// pairMember reads the value of the member at path from dec, and compares it with the member
+// of the other object buffered in others, or buffers it in pending if there is none yet.
+// fromA means dec is the decoder of A.
+func (s *jsonStream) pairMember(dec *json.Decoder, path Path, pending, others map[string]interface{},
+	depth int, fromA bool) error {
+	if s.d.isPruned(s.d.planOf(path), path) {
+		return skipValue(dec)
+	}
+	v, err := readValue(dec)
+	if err != nil {
+		return err
+	}
+	k := path.Last().Key().(string)
+	other, ok := others[k]
+	if !ok {
+		pending[k] = v
+		return nil
+	}
+	delete(others, k)
+	if fromA {
+		s.compareValues(v, other, path, depth)
+	} else {
+		s.compareValues(other, v, path, depth)
+	}
+	return nil
+}
+
+func (s *jsonStream) compareArray(path Path, depth int) error {
+	i := 0
+	for ; s.a.More() && s.b.More(); i++ {
+		if err := s.compare(path.Index(i), depth); err != nil {
+			return err
+		}
+	}
+	for _, side := range []struct {
+		dec     *json.Decoder
+		removed bool
+	}{{s.a, true}, {s.b, false}} {
+		for j := i; side.dec.More(); j++ {
+			v, err := readValue(side.dec)
+			if err != nil {
+				return err
+			}
+			s.reportMissing(path.Index(j), v, side.removed)
+		}
+	}
+	return readEnd(s.a, s.b)
+}
+
+func (s *jsonStream) reportRestMembers(dec *json.Decoder, path Path, removed bool) error {
+	for dec.More() {
+		k, err := readKey(dec)
+		if err != nil {
+			return err
+		}
+		v, err := readValue(dec)
+		if err != nil {
+			return err
+		}
+		s.reportMissing(path.Key(k), v, removed)
+	}
+	return nil
+}
+
+// compareValues compares two decoded JSON values with the rules of Differ.
+func (s *jsonStream) compareValues(a, b interface{}, path Path, depth int) {
+	va, vb := reflect.ValueOf(&a).Elem(), reflect.ValueOf(&b).Elem()
+	s.d.doCompare(va, vb, path, depth)
+}
+
+func (s *jsonStream) reportMissing(path Path, v interface{}, removed bool) {
+	rv := reflect.ValueOf(&v).Elem()
+	if removed {
+		s.d.setMissingDiff(path, rv, reflect.Value{})
+		return
+	}
+	s.d.setMissingDiff(path, reflect.Value{}, rv)
+}
+
+func readKey(dec *json.Decoder) (string, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	k, ok := t.(string)
+	if !ok {
+		return "", fmt.Errorf("unexpected token %v at offset %d", t, dec.InputOffset())
+	}
+	return k, nil
+}
+
+func readValue(dec *json.Decoder) (v interface{}, err error) {
+	err = dec.Decode(&v)
+	return
+}
+
+// nextKey reads the key of the next member of the object, ok is false at the end of the object.
+func nextKey(dec *json.Decoder) (key string, ok bool, err error) {
+	if !dec.More() {
+		return "", false, nil
+	}
+	key, err = readKey(dec)
+	return key, err == nil, err
+}
+
+// skipValue skips the next value of dec by its tokens, without decoding it as a whole.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// readRest returns the value starting with token t, an object or an array is read till its end.
+func readRest(dec *json.Decoder, t json.Token) (interface{}, error) {
+	switch t {
+	case json.Delim('{'):
+		members := make(map[string]interface{})
+		for dec.More() {
+			k, err := readKey(dec)
+			if err != nil {
+				return nil, err
+			}
+			if members[k], err = readValue(dec); err != nil {
+				return nil, err
+			}
+		}
+		_, err := dec.Token()
+		return members, err
+	case json.Delim('['):
+		elems := make([]interface{}, 0)
+		for dec.More() {
+			v, err := readValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, v)
+		}
+		_, err := dec.Token()
+		return elems, err
+	}
+	return t, nil
+}
+
+// readEnd reads the closing delimiters of the objects or arrays being compared.
+func readEnd(decoders ...*json.Decoder) error {
+	for _, dec := range decoders {
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package sdiffer
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const tagName = "sdiffer"
+
+// fieldTag is the parsed sdiffer tag of a struct field, the options are separated by commas.
+//
+// For example:
+//
+//	type Order struct {
+//		ID        string    `sdiffer:"key"`
+//		Remark    string    `sdiffer:"trimspace,name=remark"`
+//		Code      string    `sdiffer:"trim=#"`
+//		Tags      []string  `sdiffer:"unordered"`
+//		Price     float64   `sdiffer:"tolerance=0.001"`
+//		UpdatedAt time.Time `sdiffer:"-"`
+//	}
+//
+// "-" ignores the field, "key" pairs the elements of the slices of the struct by the field,
+// "unordered" compares the elements of a slice field regardless of their order, "name" replaces
+// the field name in the paths of the diffs, "trimspace", "trim" and "tolerance" apply to the
+// field and the elements it holds, but not to the fields of the nested structs.
+type fieldTag struct {
+	ignore    bool
+	trimSpace bool
+	hasTrim   bool
+	cutset    string
+	unordered bool
+	key       bool
+	tolerance float64
+	name      string
+}
+
+type parsedTag struct {
+	tag *fieldTag
+	err error
+}
+
+// fieldTagCache caches the parsed tags by their values.
+var fieldTagCache sync.Map
+
+// tagOf returns the sdiffer tag of field, it's nil if the field has no sdiffer tag.
+func tagOf(field reflect.StructField) (*fieldTag, error) {
+	value, ok := field.Tag.Lookup(tagName)
+	if !ok {
+		return nil, nil
+	}
+	if parsed, ok := fieldTagCache.Load(value); ok {
+		return parsed.(parsedTag).tag, parsed.(parsedTag).err
+	}
+	tag, err := parseFieldTag(value)
+	if err != nil {
+		err = &InvalidRuleError{Rule: "tag", Expr: value, Err: err}
+	}
+	fieldTagCache.Store(value, parsedTag{tag: tag, err: err})
+	return tag, err
+}
+
+func parseFieldTag(value string) (*fieldTag, error) {
+	tag := &fieldTag{}
+	if value == "-" {
+		tag.ignore = true
+		return tag, nil
+	}
+	for _, opt := range strings.Split(value, ",") {
+		name, arg := opt, ""
+		if i := strings.Index(opt, "="); i >= 0 {
+			name, arg = opt[:i], opt[i+1:]
+		}
+		switch strings.TrimSpace(name) {
+		case "":
+		case "trimspace":
+			tag.trimSpace = true
+		case "trim":
+			tag.hasTrim, tag.cutset = true, arg
+		case "unordered":
+			tag.unordered = true
+		case "key":
+			tag.key = true
+		case "tolerance":
+			tolerance, err := strconv.ParseFloat(arg, 64)
+			if err != nil || tolerance < 0 {
+				return nil, fmt.Errorf("invalid tolerance %q", arg)
+			}
+			tag.tolerance = tolerance
+		case "name":
+			tag.name = arg
+		default:
+			return nil, fmt.Errorf("unknown option %q", name)
+		}
+	}
+	return tag, nil
+}
+
+// displayName returns the name of a FieldStep used in the canonical form of a Path.
+func (s PathStep) displayName() string {
+	if tag, _ := tagOf(s.structField()); tag != nil && tag.name != "" {
+		return tag.name
+	}
+	return s.name
+}
+
+// tagSliceKeyCache caches the slice keys built from the key tags by the element types.
+var tagSliceKeyCache sync.Map
+
+// tagSliceKey returns the slice key of the slices whose elements are of type elemType,
+// it's nil if the elements are not structs with a field tagged as key.
+func tagSliceKey(elemType reflect.Type) *sliceKey {
+	if sk, ok := tagSliceKeyCache.Load(elemType); ok {
+		return sk.(*sliceKey)
+	}
+	var sk *sliceKey
+	t := elemType
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct {
+		for i, field := range structFields(t) {
+			if tag, _ := tagOf(field); tag != nil && tag.key {
+				sk = &sliceKey{name: PathStep{name: field.Name, index: i, owner: t}.displayName(), keyFunc: keyFieldFunc(i)}
+				break
+			}
+		}
+	}
+	tagSliceKeyCache.Store(elemType, sk)
+	return sk
+}
+
+// keyFieldFunc returns a key function which reads the i-th field of the struct elements.
+func keyFieldFunc(i int) func(elem interface{}) interface{} {
+	return func(elem interface{}) interface{} {
+		v := reflect.ValueOf(elem)
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.Elem()
+		}
+		f := v.Field(i)
+		if !f.CanInterface() {
+			f = exportValue(addressableValue(v).Field(i))
+		}
+		return f.Interface()
+	}
+}
+
+// tagSorter sorts the slices tagged as unordered, see compareOrder.
+type tagSorter struct{}
+
+func (tagSorter) Match(string) bool {
+	return true
+}
+
+func (tagSorter) Less(a, b interface{}) bool {
+	return compareOrder(reflect.ValueOf(a), reflect.ValueOf(b)) < 0
+}
+
+// compareOrder defines an arbitrary but deterministic order of values, which is used to sort
+// the slices tagged as unordered. It returns -1, 0 or 1.
+func compareOrder(a, b reflect.Value) int {
+	if !a.IsValid() || !b.IsValid() {
+		return compareInt(boolInt(a.IsValid()), boolInt(b.IsValid()))
+	}
+	if a.Type() != b.Type() {
+		return strings.Compare(a.Type().String(), b.Type().String())
+	}
+	switch a.Kind() {
+	case reflect.Bool:
+		return compareInt(boolInt(a.Bool()), boolInt(b.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareInt(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch {
+		case a.Uint() < b.Uint():
+			return -1
+		case a.Uint() > b.Uint():
+			return 1
+		}
+		return 0
+	case reflect.Float32, reflect.Float64:
+		switch {
+		case a.Float() < b.Float():
+			return -1
+		case a.Float() > b.Float():
+			return 1
+		}
+		return 0
+	case reflect.String:
+		return strings.Compare(a.String(), b.String())
+	case reflect.Ptr, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return compareInt(boolInt(!a.IsNil()), boolInt(!b.IsNil()))
+		}
+		return compareOrder(a.Elem(), b.Elem())
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if c := compareOrder(a.Field(i), b.Field(i)); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < minInt(a.Len(), b.Len()); i++ {
+			if c := compareOrder(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+		return compareInt(int64(a.Len()), int64(b.Len()))
+	}
+	return strings.Compare(fmt.Sprint(valueInterface(a)), fmt.Sprint(valueInterface(b)))
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
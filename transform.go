@@ -0,0 +1,61 @@
+package sdiffer
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+type transform struct {
+	fieldRegexp *regexp.Regexp
+	fn          func(v interface{}) interface{}
+}
+
+func newTransform(exp string, fn func(v interface{}) interface{}) (*transform, error) {
+	r, err := regexp.Compile(exp)
+	if err != nil {
+		return nil, &InvalidRuleError{Rule: "transform", Expr: exp, Err: err}
+	}
+	return &transform{fieldRegexp: r, fn: fn}, nil
+}
+
+// compareTransformed transforms a and b with fn, and compares the results instead of them.
+// The results are compared with their dynamic types if they have the same type, otherwise they
+// are compared as interfaces, so that a nil or a different type is reported as a diff.
+func (d *Differ) compareTransformed(a, b reflect.Value, path Path, fn func(v interface{}) interface{}, depth int) {
+	ra, rb := fn(d.interfaceOf(a, path)), fn(d.interfaceOf(b, path))
+	ta, tb := reflect.ValueOf(&ra).Elem(), reflect.ValueOf(&rb).Elem()
+	if ra != nil && rb != nil && reflect.TypeOf(ra) == reflect.TypeOf(rb) {
+		ta, tb = ta.Elem(), tb.Elem()
+	}
+	d.doCompare(ta, tb, path.next(PathStep{kind: TransformStep}, ta, tb), depth)
+}
+
+// Original returns the values of A and B before they were transformed by a transformer set by
+// WithTransform, ok is false if the diff is not found in transformed values.
+func (d *Diff) Original() (a, b interface{}, ok bool) {
+	for k := len(d.path) - 1; k > 0; k-- {
+		if d.path[k].kind == TransformStep {
+			va, vb := d.path[k-1].Values()
+			return optionalInterface(va), optionalInterface(vb), true
+		}
+	}
+	return nil, nil, false
+}
+
+// showOriginal makes the diff found in a transformed value display the original values of it
+// as well, the innermost transformed value is used if there are nested ones.
+func (d *Diff) showOriginal() {
+	if a, b, ok := d.Original(); ok {
+		d.va, d.vb = withOriginal(d.va, a), withOriginal(d.vb, b)
+	}
+}
+
+// withOriginal appends the original value to the displayed value v, unless they look the same.
+func withOriginal(v, original interface{}) interface{} {
+	s := fmt.Sprint(v)
+	if s == fmt.Sprint(original) {
+		return v
+	}
+	return fmt.Sprintf("%s (original: %v)", s, original)
+}
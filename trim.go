@@ -10,11 +10,15 @@ type trimTag struct {
 	cutset      string
 }
 
-func newTrimTag(exp, cutset string) *trimTag {
+func newTrimTag(exp, cutset string) (*trimTag, error) {
+	r, err := regexp.Compile(exp)
+	if err != nil {
+		return nil, &InvalidRuleError{Rule: "trim", Expr: exp, Err: err}
+	}
 	return &trimTag{
-		fieldRegexp: regexp.MustCompile(exp),
+		fieldRegexp: r,
 		cutset:      cutset,
-	}
+	}, nil
 }
 
 func (tt *trimTag) Trim(s string) string {
@@ -1,9 +1,13 @@
 package sdiffer
 
 import (
+	"encoding/json"
 	"fmt"
+	"math/big"
 	"reflect"
+	"sort"
 	"strings"
+	"unsafe"
 )
 
 func isStringBlank(str string) bool {
@@ -36,7 +40,12 @@ func iF(condition bool, a, b interface{}) interface{} {
 }
 
 func concat(strList ...string) string {
+	n := 0
+	for _, str := range strList {
+		n += len(str)
+	}
 	builder := &strings.Builder{}
+	builder.Grow(n)
 	for _, str := range strList {
 		builder.WriteString(str)
 	}
@@ -54,6 +63,82 @@ func minInt(a, b int) int {
 	return b
 }
 
+// addressableValue returns v itself if it is addressable, or else an addressable copy of v.
+func addressableValue(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v
+	}
+	copied := reflect.New(v.Type()).Elem()
+	copied.Set(v)
+	return copied
+}
+
+// exportValue makes an addressable value obtained from an unexported field readable.
+func exportValue(v reflect.Value) reflect.Value {
+	return reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem()
+}
+
+// deepCopy returns a deep copy of v, copied records the copied pointers to keep cycles.
+func deepCopy(v reflect.Value, copied map[visit]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		key := visit{v.Type(), v.Pointer()}
+		if c, ok := copied[key]; ok {
+			return c
+		}
+		c := reflect.New(v.Type().Elem())
+		copied[key] = c
+		c.Elem().Set(deepCopy(v.Elem(), copied))
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < c.NumField(); i++ {
+			f := c.Field(i)
+			if !f.CanSet() {
+				f = exportValue(f)
+			}
+			f.Set(deepCopy(f, copied))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), copied))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), copied))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			c.SetMapIndex(k, deepCopy(v.MapIndex(k), copied))
+		}
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem(), copied))
+		return c
+	}
+	return v
+}
+
 func copySliceValue(sv reflect.Value) reflect.Value {
 	length := sv.Len()
 	copiedSv := reflect.MakeSlice(sv.Type(), length, length)
@@ -63,6 +148,42 @@ func copySliceValue(sv reflect.Value) reflect.Value {
 	return copiedSv
 }
 
+var stringType = reflect.TypeOf("")
+
+// unionMapKeys returns the keys of both maps without duplicates, sorted by their string form.
+func unionMapKeys(a, b reflect.Value) []reflect.Value {
+	keys := a.MapKeys()
+	for _, k := range b.MapKeys() {
+		if !a.MapIndex(k).IsValid() {
+			keys = append(keys, k)
+		}
+	}
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		// a named string type may be a fmt.Stringer.
+		if k.Type() == stringType {
+			names[i] = k.String()
+		} else {
+			names[i] = toString(k)
+		}
+	}
+	sort.Sort(mapKeys{keys: keys, names: names})
+	return keys
+}
+
+// mapKeys sorts the keys of maps by their string form in names.
+type mapKeys struct {
+	keys  []reflect.Value
+	names []string
+}
+
+func (m mapKeys) Len() int           { return len(m.keys) }
+func (m mapKeys) Less(i, j int) bool { return m.names[i] < m.names[j] }
+func (m mapKeys) Swap(i, j int) {
+	m.keys[i], m.keys[j] = m.keys[j], m.keys[i]
+	m.names[i], m.names[j] = m.names[j], m.names[i]
+}
+
 func parseStringValue(a, b reflect.Value) (as, bs reflect.Value, ok bool) {
 	ai, bi := a.Interface(), b.Interface()
 	_, ok = ai.(string)
@@ -73,6 +194,26 @@ func parseStringValue(a, b reflect.Value) (as, bs reflect.Value, ok bool) {
 	return
 }
 
+func parseNumberValue(a, b reflect.Value) (as, bs reflect.Value, ok bool) {
+	ai, bi := a.Interface(), b.Interface()
+	_, ok = ai.(json.Number)
+	if !ok {
+		return
+	}
+	as, bs = reflect.ValueOf(ai), reflect.ValueOf(bi)
+	return
+}
+
+// isNumberEqual checks if two JSON numbers are numerically equal, such as 1, 1.0 and 1e0.
+func isNumberEqual(a, b json.Number) bool {
+	if a == b {
+		return true
+	}
+	ra, okA := new(big.Rat).SetString(string(a))
+	rb, okB := new(big.Rat).SetString(string(b))
+	return okA && okB && ra.Cmp(rb) == 0
+}
+
 func parseFloatValue(a, b reflect.Value) (as, bs reflect.Value, ok bool) {
 	ai, bi := a.Interface(), b.Interface()
 	_, ok = ai.(float64)
@@ -112,3 +253,19 @@ func parseMapValue(a, b reflect.Value) (as, bs reflect.Value, ok bool) {
 	as, bs = reflect.ValueOf(ai), reflect.ValueOf(bi)
 	return
 }
+
+func copyTypeSet(set map[reflect.Type]bool) map[reflect.Type]bool {
+	copied := make(map[reflect.Type]bool, len(set))
+	for t, v := range set {
+		copied[t] = v
+	}
+	return copied
+}
+
+func copyTypeComparators(cmps map[reflect.Type]func(a, b interface{}) bool) map[reflect.Type]func(a, b interface{}) bool {
+	copied := make(map[reflect.Type]func(a, b interface{}) bool, len(cmps))
+	for t, fn := range cmps {
+		copied[t] = fn
+	}
+	return copied
+}